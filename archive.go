@@ -0,0 +1,151 @@
+package migration
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// FromArchive reads migration files from a tar, tar.gz (or tgz) or zip
+// archive, as given by format, and returns the migrations sorted by ID, as
+// LoadMigrations does. This allows shipping migrations as a verified bundle
+// rather than embedding them in the binary.
+//
+// Files are identified by their base name, so the archive may keep them in a
+// directory. Entries with absolute paths or paths escaping the archive root,
+// and files with the same base name, are rejected.
+func FromArchive(r io.Reader, format string, opts ...Option) ([]*Migration, error) {
+	var (
+		files map[string]string
+		err   error
+	)
+
+	switch strings.ToLower(format) {
+	case "tar":
+		files, err = readTar(r)
+	case "tar.gz", "tgz":
+		gz, gzErr := gzip.NewReader(r)
+		if gzErr != nil {
+			return nil, fmt.Errorf("error reading gzip archive: %s", gzErr)
+		}
+		defer gz.Close()
+		files, err = readTar(gz)
+	case "zip":
+		files, err = readZip(r)
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadMigrations(&MemoryMigrationSource{Files: files}, opts...)
+}
+
+func readTar(r io.Reader) (map[string]string, error) {
+	files := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar archive: %s", err)
+		}
+
+		name, err := archiveFileName(header.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			return nil, fmt.Errorf("archive entry %q is not a regular file", header.Name)
+		}
+
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive entry %q: %s", header.Name, err)
+		}
+
+		if err := addArchiveFile(files, name, header.Name, contents); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readZip(r io.Reader) (map[string]string, error) {
+	// zip needs random access to read the central directory at the end.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip archive: %s", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip archive: %s", err)
+	}
+
+	files := map[string]string{}
+
+	for _, f := range zr.File {
+		name, err := archiveFileName(f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("archive entry %q is not a regular file", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive entry %q: %s", f.Name, err)
+		}
+		contents, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive entry %q: %s", f.Name, err)
+		}
+
+		if err := addArchiveFile(files, name, f.Name, contents); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// archiveFileName returns the base name of the archive entry called name,
+// rejecting names that would resolve outside the archive root.
+func archiveFileName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	cleaned := path.Clean(slashed)
+
+	if path.IsAbs(slashed) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.Contains(name, "\x00") {
+		return "", fmt.Errorf("archive entry %q has an unsafe path", name)
+	}
+
+	return path.Base(cleaned), nil
+}
+
+func addArchiveFile(files map[string]string, name, entry string, contents []byte) error {
+	if _, ok := files[name]; ok {
+		return fmt.Errorf("archive contains several files named %s, found again at %q", name, entry)
+	}
+	files[name] = string(contents)
+	return nil
+}
@@ -0,0 +1,113 @@
+package migration
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+var archiveFiles = []struct {
+	name, contents string
+}{
+	{"migrations/1_init.up.sql", "CREATE TABLE users (id integer);\n"},
+	{"migrations/1_init.down.sql", "DROP TABLE users;\n"},
+	{"migrations/2_roles.up.sql", "CREATE TABLE roles (id integer);\n"},
+	{"migrations/2_roles.down.sql", "DROP TABLE roles;\n"},
+}
+
+func buildTar(t *testing.T, files []struct{ name, contents string }) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.contents)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, files []struct{ name, contents string }) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFromArchive(t *testing.T) {
+	tarball := buildTar(t, archiveFiles)
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	if _, err := gw.Write(tarball); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archives := map[string][]byte{
+		"tar":    tarball,
+		"tar.gz": gzipped.Bytes(),
+		"zip":    buildZip(t, archiveFiles),
+	}
+
+	for format, data := range archives {
+		m, err := FromArchive(bytes.NewReader(data), format)
+		if err != nil {
+			t.Errorf("Unexpected error reading %s archive: %s", format, err)
+			continue
+		}
+
+		if len(m) != 2 || m[0].ID != "1_init" || m[1].ID != "2_roles" {
+			t.Errorf("Expected 1_init and 2_roles from %s archive, got %v", format, m)
+			continue
+		}
+		if m[1].Up.Statements[0] != "CREATE TABLE roles (id integer);\n" || m[1].Down.Statements[0] != "DROP TABLE roles;\n" {
+			t.Errorf("Expected both directions of 2_roles to be read from %s archive, got %v and %v", format, m[1].Up.Statements, m[1].Down.Statements)
+		}
+	}
+}
+
+func TestFromArchiveRejectsUnsafePaths(t *testing.T) {
+	for _, name := range []string{"../1_init.up.sql", "migrations/../../1_init.up.sql", "/etc/1_init.up.sql"} {
+		files := []struct{ name, contents string }{{name, "CREATE TABLE users (id integer);\n"}}
+
+		if _, err := FromArchive(bytes.NewReader(buildTar(t, files)), "tar"); err == nil {
+			t.Errorf("Expected an error for tar entry %q", name)
+		}
+		if _, err := FromArchive(bytes.NewReader(buildZip(t, files)), "zip"); err == nil {
+			t.Errorf("Expected an error for zip entry %q", name)
+		}
+	}
+
+	duplicate := []struct{ name, contents string }{
+		{"a/1_init.up.sql", "CREATE TABLE users (id integer);\n"},
+		{"b/1_init.up.sql", "CREATE TABLE roles (id integer);\n"},
+	}
+	if _, err := FromArchive(bytes.NewReader(buildZip(t, duplicate)), "zip"); err == nil {
+		t.Error("Expected an error for files with the same name in different directories")
+	}
+
+	if _, err := FromArchive(bytes.NewReader(nil), "rar"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/muxinc/migration/parser"
+)
+
+const identifierPattern = `((?:"[^"]+"|[\w]+)(?:\.(?:"[^"]+"|[\w]+))?)`
+
+var (
+	createTableRegex = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?` + identifierPattern + `\s*\(`)
+	createIndexRegex = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?` + identifierPattern + `\s+ON\s`)
+	addColumnRegex   = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?` + identifierPattern + `\s+ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?` + identifierPattern + `\s`)
+	multipleAddRegex = regexp.MustCompile(`(?is),\s*ADD\s`)
+)
+
+// tableConstraintKeywords follow ADD when adding a constraint rather than a
+// column.
+var tableConstraintKeywords = map[string]bool{
+	"CONSTRAINT": true,
+	"PRIMARY":    true,
+	"UNIQUE":     true,
+	"CHECK":      true,
+	"FOREIGN":    true,
+	"EXCLUDE":    true,
+}
+
+// AutoDown generates a down migration that inverts up. Only CREATE TABLE,
+// CREATE INDEX and ALTER TABLE ... ADD COLUMN statements can be inverted, into
+// DROP TABLE, DROP INDEX and DROP COLUMN respectively, in reverse order. For
+// any other statement, an error is returned and the down migration must be
+// written explicitly.
+func AutoDown(up *parser.ParsedMigration) (*parser.ParsedMigration, error) {
+	down := &parser.ParsedMigration{
+		UseTransaction: up.UseTransaction,
+		Statements:     []string{},
+	}
+
+	var inverted []string
+
+	for _, statement := range splitStatements(up) {
+		inverse, err := invertStatement(statement)
+		if err != nil {
+			return nil, err
+		}
+		inverted = append(inverted, inverse)
+	}
+
+	if len(inverted) == 0 {
+		return nil, fmt.Errorf("no statements to invert")
+	}
+
+	for i := len(inverted) - 1; i >= 0; i-- {
+		down.Statements = append(down.Statements, inverted[i])
+	}
+
+	return down, nil
+}
+
+func invertStatement(statement string) (string, error) {
+	if matches := createTableRegex.FindStringSubmatch(statement); matches != nil {
+		return fmt.Sprintf("DROP TABLE %s;", matches[1]), nil
+	}
+
+	if matches := createIndexRegex.FindStringSubmatch(statement); matches != nil {
+		if matches[1] != "" {
+			return fmt.Sprintf("DROP INDEX CONCURRENTLY %s;", matches[2]), nil
+		}
+		return fmt.Sprintf("DROP INDEX %s;", matches[2]), nil
+	}
+
+	if matches := addColumnRegex.FindStringSubmatch(statement); matches != nil && !multipleAddRegex.MatchString(statement) && !tableConstraintKeywords[strings.ToUpper(matches[2])] {
+		return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", matches[1], matches[2]), nil
+	}
+
+	return "", fmt.Errorf("unable to invert statement, an explicit down migration is required: %s", statement)
+}
+
+// splitStatements returns the individual statements of parsed, without
+// comment lines and surrounding whitespace.
+func splitStatements(parsed *parser.ParsedMigration) []string {
+	var result []string
+
+	if parsed == nil {
+		return result
+	}
+
+	for _, statements := range parsed.Statements {
+		for _, statement := range parser.SplitStatements(statements) {
+			statement = stripLineComments(statement)
+			if statement == "" || statement == ";" {
+				continue
+			}
+			result = append(result, statement)
+		}
+	}
+
+	return result
+}
+
+// stripLineComments removes lines consisting only of a comment and trims
+// surrounding whitespace.
+func stripLineComments(statement string) string {
+	var lines []string
+	for _, line := range strings.Split(statement, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
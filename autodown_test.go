@@ -0,0 +1,72 @@
+package migration
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muxinc/migration/parser"
+)
+
+func TestAutoDown(t *testing.T) {
+	up, err := parser.Parse(strings.NewReader(`-- description: Create users
+CREATE TABLE IF NOT EXISTS users (id integer not null primary key, balance numeric(10, 2));
+CREATE UNIQUE INDEX users_balance_idx ON users (balance);
+ALTER TABLE users ADD COLUMN name text;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down, err := AutoDown(up)
+	if err != nil {
+		t.Fatalf("Unexpected error while generating down migration: %s", err)
+	}
+
+	expected := []string{
+		"ALTER TABLE users DROP COLUMN name;",
+		"DROP INDEX users_balance_idx;",
+		"DROP TABLE users;",
+	}
+	if !reflect.DeepEqual(down.Statements, expected) {
+		t.Errorf("Expected down statements to be %q, got %q", expected, down.Statements)
+	}
+	if !down.UseTransaction {
+		t.Error("Expected the down migration to use a transaction like the up migration")
+	}
+
+	for _, statement := range []string{
+		"UPDATE users SET balance = 0;",
+		"ALTER TABLE users ADD COLUMN a text, ADD COLUMN b text;",
+		"ALTER TABLE users ADD CONSTRAINT positive_balance CHECK (balance >= 0);",
+		"DROP TABLE users;",
+	} {
+		if _, err := AutoDown(&parser.ParsedMigration{Statements: []string{statement}}); err == nil {
+			t.Errorf("Expected an error when inverting %q", statement)
+		}
+	}
+}
+
+func TestGetMigrationsWithAutoDown(t *testing.T) {
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "CREATE TABLE users (id integer not null primary key);",
+			"002_update.up.sql": "UPDATE users SET id = id + 1;",
+		},
+	}
+
+	_, err := getMigrations(memoryMigration, newOptions([]Option{WithAutoDown()}))
+	if err == nil || !strings.Contains(err.Error(), "002_update") {
+		t.Errorf("Expected an error naming the migration that cannot be inverted, got %v", err)
+	}
+
+	delete(memoryMigration.Files, "002_update.up.sql")
+
+	migrations, err := getMigrations(memoryMigration, newOptions([]Option{WithAutoDown()}))
+	if err != nil {
+		t.Fatalf("Unexpected error while loading migrations: %s", err)
+	}
+	if !reflect.DeepEqual(migrations[0].Down.Statements, []string{"DROP TABLE users;"}) {
+		t.Errorf("Expected a generated down migration, got %q", migrations[0].Down.Statements)
+	}
+}
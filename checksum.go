@@ -0,0 +1,45 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/muxinc/migration/parser"
+)
+
+// Checksum returns a hex-encoded SHA-256 digest of the statements and
+// transaction modes of the up and down migrations. It changes whenever the
+// SQL that would be run changes, but not when only comments that the parser
+// strips, such as the description, are edited.
+//
+// For migrations loaded from files, the files are hashed as written: before
+// running them through the template of WithTemplate, and without the down
+// migration generated by WithAutoDown, so that changing the template data or
+// enabling WithAutoDown doesn't change the checksum of applied migrations.
+// Repeatable migrations are hashed after templating, so that they are
+// applied again when their template data changes.
+func (m *Migration) Checksum() string {
+	h := sha256.New()
+
+	up, down := m.Up, m.Down
+	if m.sourced {
+		up, down = m.sourceUp, m.sourceDown
+	}
+
+	for _, parsed := range []*parser.ParsedMigration{up, down} {
+		if parsed == nil {
+			io.WriteString(h, "-\n")
+			continue
+		}
+
+		fmt.Fprintf(h, "%t %d\n", parsed.UseTransaction, len(parsed.Statements))
+		for _, statement := range parsed.Statements {
+			// Prefixing the length keeps statement boundaries significant.
+			fmt.Fprintf(h, "%d:%s", len(statement), statement)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
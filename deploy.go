@@ -0,0 +1,26 @@
+package migration
+
+import "context"
+
+// DeployIDMetadataKey is the metadata key drivers record the deploy ID of the
+// context under, see ContextWithDeployID.
+const DeployIDMetadataKey = "deploy_id"
+
+// deployIDKey is the context key of the deploy ID.
+type deployIDKey struct{}
+
+// ContextWithDeployID returns a copy of ctx carrying deployID, such as the ID
+// of the deploy running the migrations. Drivers that record metadata store it
+// under DeployIDMetadataKey with each migration applied with the context,
+// unless the PlannedMigration's Metadata sets that key itself, so the deploy
+// ID doesn't need to be threaded through every call.
+func ContextWithDeployID(ctx context.Context, deployID string) context.Context {
+	return context.WithValue(ctx, deployIDKey{}, deployID)
+}
+
+// DeployIDFromContext returns the deploy ID set on ctx with
+// ContextWithDeployID, if any.
+func DeployIDFromContext(ctx context.Context) (string, bool) {
+	deployID, ok := ctx.Value(deployIDKey{}).(string)
+	return deployID, ok && deployID != ""
+}
@@ -0,0 +1,21 @@
+package migration
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeployIDFromContext(t *testing.T) {
+	if _, ok := DeployIDFromContext(context.Background()); ok {
+		t.Error("Expected no deploy ID in an empty context")
+	}
+
+	ctx := ContextWithDeployID(context.Background(), "deploy-1234")
+	if deployID, ok := DeployIDFromContext(ctx); !ok || deployID != "deploy-1234" {
+		t.Errorf("Expected deploy ID %q, got %q", "deploy-1234", deployID)
+	}
+
+	if _, ok := DeployIDFromContext(ContextWithDeployID(context.Background(), "")); ok {
+		t.Error("Expected an empty deploy ID to be ignored")
+	}
+}
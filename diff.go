@@ -0,0 +1,56 @@
+package migration
+
+import "sort"
+
+// SetDiff describes how a set of migrations changed. Each list is in
+// canonical order.
+type SetDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// Empty reports whether the sets were identical.
+func (d SetDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffSets compares two sets of migrations, such as those loaded with
+// LoadMigrations before and after a change. Migrations are matched by ID, and
+// a migration present in both sets is modified if its checksum changed. A CI
+// check can use it to flag edits to migrations that have already been merged.
+func DiffSets(before, after []*Migration) SetDiff {
+	var diff SetDiff
+
+	checksums := map[string]string{}
+	for _, migration := range before {
+		checksums[migration.ID] = migration.Checksum()
+	}
+
+	seen := map[string]bool{}
+	for _, migration := range after {
+		seen[migration.ID] = true
+
+		checksum, ok := checksums[migration.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, migration.ID)
+		case checksum != migration.Checksum():
+			diff.Modified = append(diff.Modified, migration.ID)
+		}
+	}
+
+	for _, migration := range before {
+		if !seen[migration.ID] {
+			diff.Removed = append(diff.Removed, migration.ID)
+		}
+	}
+
+	for _, ids := range [][]string{diff.Added, diff.Removed, diff.Modified} {
+		sort.Slice(ids, func(i, j int) bool {
+			return CompareIDs(ids[i], ids[j]) < 0
+		})
+	}
+
+	return diff
+}
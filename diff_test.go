@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSets(t *testing.T) {
+	before, err := LoadMigrations(&MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":      "CREATE TABLE users (id integer);\n",
+			"1_init.down.sql":    "DROP TABLE users;\n",
+			"2_roles.up.sql":     "-- description: Add roles\nCREATE TABLE roles (id integer);\n",
+			"3_accounts.up.sql":  "CREATE TABLE accounts (id integer);\n",
+			"10_orders.up.sql":   "CREATE TABLE orders (id integer);\n",
+			"10_orders.down.sql": "DROP TABLE orders;\n",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := LoadMigrations(&MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":      "CREATE TABLE users (id integer);\n",
+			"1_init.down.sql":    "DROP TABLE users;\n",
+			"2_roles.up.sql":     "-- description: Add the roles table\nCREATE TABLE roles (id integer);\n",
+			"10_orders.up.sql":   "CREATE TABLE orders (id bigint);\n",
+			"10_orders.down.sql": "DROP TABLE orders;\n",
+			"4_grants.up.sql":    "CREATE TABLE grants (id integer);\n",
+			"11_audit.up.sql":    "-- +migration NoTransaction\nCREATE TABLE audit (id integer);\n",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := SetDiff{
+		Added:    []string{"4_grants", "11_audit"},
+		Removed:  []string{"3_accounts"},
+		Modified: []string{"10_orders"},
+	}
+	if diff := DiffSets(before, after); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected diff to be %+v, got %+v", expected, diff)
+	}
+
+	if diff := DiffSets(before, before); !diff.Empty() {
+		t.Errorf("Expected no differences between identical sets, got %+v", diff)
+	}
+}
+
+func TestMigrationChecksum(t *testing.T) {
+	migrations, err := LoadMigrations(&MemoryMigrationSource{
+		Files: map[string]string{
+			"1_a.up.sql": "-- +migration NoTransaction\nSELECT 1;\nSELECT 2;\n",
+			"2_b.up.sql": "-- +migration NoTransaction\nSELECT 1;SELECT 2;\n",
+			"3_c.up.sql": "SELECT 1;\nSELECT 2;\n",
+			"4_d.up.sql": "-- +migration NoTransaction\nSELECT 1;\nSELECT 2;\n",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksums := map[string]string{}
+	for _, migration := range migrations {
+		checksums[migration.ID] = migration.Checksum()
+	}
+
+	if checksums["1_a"] != checksums["4_d"] {
+		t.Error("Expected migrations with the same statements to have the same checksum")
+	}
+	if checksums["1_a"] == checksums["2_b"] {
+		t.Error("Expected a change in statements to change the checksum")
+	}
+	if checksums["1_a"] == checksums["3_c"] {
+		t.Error("Expected a change in transaction mode to change the checksum")
+	}
+}
+
+func TestMigrationChecksumIgnoresLoadOptions(t *testing.T) {
+	source := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_events.up.sql": "{{range .Partitions}}CREATE TABLE events_{{.}} (id integer);\n{{end}}",
+		},
+	}
+
+	checksum := func(opts ...Option) string {
+		migrations, err := LoadMigrations(source, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrations[0].Checksum()
+	}
+
+	two := checksum(WithTemplate(map[string][]int{"Partitions": {1, 2}}))
+	if three := checksum(WithTemplate(map[string][]int{"Partitions": {1, 2, 3}})); three != two {
+		t.Error("Expected changing the template data not to change the checksum")
+	}
+	if autoDown := checksum(WithTemplate(map[string][]int{"Partitions": {1, 2}}), WithAutoDown()); autoDown != two {
+		t.Error("Expected generating the down migration not to change the checksum")
+	}
+
+	source.Files["1_events.up.sql"] = "{{range .Partitions}}CREATE TABLE events_{{.}} (id bigint);\n{{end}}"
+	if edited := checksum(WithTemplate(map[string][]int{"Partitions": {1, 2}})); edited == two {
+		t.Error("Expected editing the file to change the checksum")
+	}
+}
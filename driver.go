@@ -1,6 +1,12 @@
 package migration
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/muxinc/migration/parser"
+)
 
 // Driver is the interface type that needs to implemented by all drivers.
 type Driver interface {
@@ -17,6 +23,156 @@ type Driver interface {
 	// Context can be used to cancel any incomplete migrations.
 	Migrate(ctx context.Context, migration *PlannedMigration) error
 
-	// Version returns all applied migration versions
+	// Version returns all applied migration versions.
+	//
+	// Versions should be returned in the order they were applied, oldest
+	// first, as rollbacks undo migrations in the reverse of this order.
+	// Drivers that cannot tell the order should return them in ascending
+	// order.
 	Versions(ctx context.Context) ([]string, error)
 }
+
+// AppliedMigration describes a migration recorded by a driver.
+type AppliedMigration struct {
+	ID       string
+	Metadata map[string]string
+
+	// AppliedAt is when the migration was applied, or the zero time if the
+	// driver doesn't know.
+	AppliedAt time.Time
+
+	// Checksum is the Checksum of the migration when it was applied, or empty
+	// if the driver doesn't know.
+	Checksum string
+}
+
+// AppliedMigrationLister is implemented by drivers that record details of
+// applied migrations. It is used to check whether applied migrations have
+// been edited since, see WithChecksumMode.
+type AppliedMigrationLister interface {
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+}
+
+// Execer is implemented by drivers that can run statements without recording
+// a version. It is used for work that must not become part of the migration
+// history, such as seeding data.
+type Execer interface {
+	// Exec runs the statements, honouring UseTransaction, without touching
+	// the version table.
+	Exec(ctx context.Context, statements *parser.ParsedMigration) error
+}
+
+// Locker is implemented by drivers that can prevent concurrent migration runs,
+// for example when several instances of an application start at once. Migrate
+// acquires the lock before reading the applied versions and releases it after
+// the last migration has been attempted.
+type Locker interface {
+	// Lock blocks until the lock is acquired. Drivers should return a
+	// LockTimeoutError if the lock could not be acquired in time.
+	Lock(ctx context.Context) error
+
+	// Unlock releases a lock acquired by Lock.
+	Unlock(ctx context.Context) error
+}
+
+// TransactionalDDL is implemented by drivers that can report whether their
+// backend supports transactional DDL, meaning schema changes can be rolled
+// back along with the rest of a transaction. PostgreSQL does, whereas MySQL
+// and Oracle implicitly commit on most DDL statements.
+//
+// Features that promise atomicity across migrations, such as
+// WithSingleTransaction, are refused for drivers that don't implement this
+// interface or report false.
+type TransactionalDDL interface {
+	SupportsTransactionalDDL() bool
+}
+
+// Batcher is implemented by drivers that can apply several migrations within a
+// single transaction. It is used by WithSingleTransaction.
+type Batcher interface {
+	// MigrateBatch applies all migrations and records their versions in one
+	// transaction. If any migration fails, none of them are applied.
+	MigrateBatch(ctx context.Context, migrations []*PlannedMigration) error
+}
+
+// SavepointBatcher is implemented by drivers that can apply several migrations
+// within a single transaction, each in its own savepoint. It is used by
+// WithSavepoints.
+type SavepointBatcher interface {
+	// MigrateWithSavepoints applies migrations in order in one transaction,
+	// running each in a savepoint. When a migration fails, its savepoint is
+	// rolled back and onError is called with the failure: the next migration
+	// is attempted if it returns true, and the remaining ones are skipped
+	// otherwise. The migrations that succeeded are then committed. The
+	// returned error reports failures of the transaction itself, such as
+	// the commit failing, in which case none of the migrations are applied.
+	MigrateWithSavepoints(ctx context.Context, migrations []*PlannedMigration, onError func(migration *PlannedMigration, err error) bool) error
+}
+
+// Validator is implemented by drivers that can check statements for syntax
+// errors without running them. It is used by WithPreValidate.
+type Validator interface {
+	// ValidateStatements returns an error if any statement cannot be parsed.
+	// It must not have side effects.
+	ValidateStatements(ctx context.Context, statements *parser.ParsedMigration) error
+}
+
+// GuardEvaluator is implemented by drivers that can evaluate the guards and
+// verification queries of migrations, see Migration.Guard and
+// Migration.Verify.
+type GuardEvaluator interface {
+	// EvaluateGuard runs the guard's statements and reports whether the last
+	// one returned a row whose first column is neither false nor NULL. It
+	// must not have side effects.
+	EvaluateGuard(ctx context.Context, guard *parser.ParsedMigration) (bool, error)
+}
+
+// ServerVersioner is implemented by drivers that can report the version of
+// the database server they are connected to, allowing migrations to declare
+// the oldest version they support with "-- +migration MinServerVersion: ...".
+type ServerVersioner interface {
+	// ServerVersion returns the version of the server, such as "15.2" or
+	// "8.0.32". Text following the leading dotted numbers is ignored.
+	ServerVersion(ctx context.Context) (string, error)
+}
+
+// RepeatableMigrator is implemented by drivers that support repeatable
+// migrations, see Migration.Repeatable. Their checksums are tracked
+// separately from the versions of versioned migrations.
+type RepeatableMigrator interface {
+	// RepeatableChecksums returns the checksum recorded when each repeatable
+	// migration was last applied, by ID.
+	RepeatableChecksums(ctx context.Context) (map[string]string, error)
+
+	// MigrateRepeatable runs the up statements of a repeatable migration and
+	// records its checksum, replacing the previous one.
+	MigrateRepeatable(ctx context.Context, migration *PlannedMigration) error
+}
+
+// SchemaDumper is implemented by drivers that can describe the schema of the
+// database, see DumpSchema.
+type SchemaDumper interface {
+	// DumpSchema returns a normalized description of the tables, columns and
+	// indexes of the database, sorted so that equal schemas produce equal
+	// output.
+	DumpSchema(ctx context.Context) (string, error)
+}
+
+// DumpSchema returns a normalized description of the schema of the database
+// driver is connected to, for example for tests to compare the schema
+// produced by migrations with a golden file. The driver must implement
+// SchemaDumper.
+func DumpSchema(ctx context.Context, driver Driver) (string, error) {
+	dumper, ok := driver.(SchemaDumper)
+	if !ok {
+		return "", fmt.Errorf("driver %T cannot dump its schema", driver)
+	}
+	return dumper.DumpSchema(ctx)
+}
+
+// supportsTransactionalDDL reports whether driver declares support for
+// transactional DDL.
+func supportsTransactionalDDL(driver Driver) bool {
+	d, ok := driver.(TransactionalDDL)
+	return ok && d.SupportsTransactionalDDL()
+}
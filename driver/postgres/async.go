@@ -0,0 +1,211 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// AsyncHandle identifies a migration started with MigrateAsync.
+type AsyncHandle int64
+
+// AsyncState is the state of a migration started with MigrateAsync.
+type AsyncState string
+
+const (
+	AsyncRunning AsyncState = "running"
+	AsyncDone    AsyncState = "done"
+	AsyncFailed  AsyncState = "failed"
+)
+
+// AsyncStatus reports the progress of a migration started with MigrateAsync.
+type AsyncStatus struct {
+	ID        string
+	Direction m.Direction
+	State     AsyncState
+
+	// Error is the error the migration failed with, if any.
+	Error string
+
+	StartedAt time.Time
+
+	// FinishedAt is the zero time while the migration is running.
+	FinishedAt time.Time
+}
+
+// asyncTable is the name of the table tracking the migrations started with
+// MigrateAsync.
+func (driver *Driver) asyncTable() string {
+	return driver.tableName + "_async"
+}
+
+// MigrateAsync starts running migration in the background and returns
+// immediately with a handle to poll its progress with Status, for migrations
+// that take too long for a deploy to wait for, such as building a huge index.
+// The progress is recorded in a row of the schema_migration_async table, so
+// it can be polled from other processes as well.
+//
+// The migration runs on a connection of its own, which holds the migration
+// lock while the migration runs, so it waits for any migration run holding
+// the lock, including one by the caller, and later runs wait for it. It is
+// not affected by ctx being cancelled once MigrateAsync returns. Shutdown
+// waits for it like for any other migration, and closing a pool-backed driver
+// blocks until it completes.
+//
+// Drivers created from an existing connection can't open the connection the
+// migration runs on, and the lease lock used by WithLeaseLock and transaction
+// pooling mode is not supported.
+func (driver *Driver) MigrateAsync(ctx context.Context, migration *m.PlannedMigration) (AsyncHandle, error) {
+	if migration.Direction != m.Up && migration.Direction != m.Down {
+		return 0, &m.InvalidDirectionError{Direction: migration.Direction}
+	}
+	if driver.leaseTTL > 0 {
+		return 0, errors.New("migrations cannot be run asynchronously with the lease lock")
+	}
+	if driver.pool == nil && driver.connConfig == nil {
+		return 0, errors.New("migrations can only be run asynchronously by drivers that open their own connections")
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var handle AsyncHandle
+	err = driver.createAsyncTable(ctx, conn)
+	if err == nil {
+		err = conn.QueryRow(ctx, "INSERT INTO "+driver.asyncTable()+" (version, direction, state, started_at) VALUES ($1, $2, $3, now()) RETURNING id",
+			driver.queryArgs(migration.ID, migration.Direction.String(), string(AsyncRunning))...).Scan(&handle)
+	}
+	err = closedErr(conn, err)
+	release()
+	if err != nil {
+		return 0, fmt.Errorf("error recording asynchronous migration %s: %s", migration.ID, err)
+	}
+
+	// The migration outlives ctx, but is cancelled if Shutdown stops waiting
+	// for it or the lease held by Lock is lost.
+	asyncCtx, done, err := driver.track(context.Background())
+	if err != nil {
+		driver.finishAsync(handle, err)
+		return 0, err
+	}
+
+	go func() {
+		defer done(nil)
+		driver.finishAsync(handle, driver.runAsync(asyncCtx, migration))
+	}()
+
+	return handle, nil
+}
+
+func (driver *Driver) createAsyncTable(ctx context.Context, q querier) error {
+	_, err := q.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.asyncTable()+" (id bigserial not null primary key, version text not null, direction text not null, state text not null, error text, started_at timestamptz not null, finished_at timestamptz)")
+	return err
+}
+
+// runAsync runs migration on a connection of its own, holding the migration
+// lock.
+func (driver *Driver) runAsync(ctx context.Context, migration *m.PlannedMigration) error {
+	var conn *pgx.Conn
+	if driver.pool != nil {
+		pooled, err := driver.pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer pooled.Release()
+		conn = pooled.Conn()
+	} else {
+		var err error
+		if conn, err = pgx.ConnectConfig(ctx, driver.connConfig.Copy()); err != nil {
+			return err
+		}
+		defer conn.Close(context.Background())
+
+		if driver.afterConnect != nil {
+			if err := driver.afterConnect(ctx, conn); err != nil {
+				return fmt.Errorf("error running after connect hook: %w", err)
+			}
+		}
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", driver.queryArgs(driver.advisoryLockKey())...); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %s", closedErr(conn, err))
+	}
+
+	// The session-level lock outlives the migration, so it must be released
+	// before returning a pooled connection, or the connection closed if that
+	// fails.
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", driver.queryArgs(driver.advisoryLockKey())...); err != nil {
+			conn.Close(context.Background())
+		}
+	}()
+
+	return driver.migrateOn(ctx, conn, migration)
+}
+
+// finishAsync records the outcome of the asynchronous migration handle.
+func (driver *Driver) finishAsync(handle AsyncHandle, migrationErr error) {
+	ctx := context.Background()
+
+	state, message := AsyncDone, (*string)(nil)
+	if migrationErr != nil {
+		state = AsyncFailed
+		text := migrationErr.Error()
+		message = &text
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err == nil {
+		_, err = conn.Exec(ctx, "UPDATE "+driver.asyncTable()+" SET state = $1, error = $2, finished_at = now() WHERE id = $3",
+			driver.queryArgs(string(state), message, int64(handle))...)
+		release()
+	}
+	if err != nil {
+		driver.logger.Printf("Unable to record the outcome of asynchronous migration %d (%s, %v): %s", handle, state, migrationErr, err)
+	}
+}
+
+// Status reports the progress of a migration started with MigrateAsync.
+func (driver *Driver) Status(ctx context.Context, handle AsyncHandle) (*AsyncStatus, error) {
+	conn, release, err := driver.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var (
+		status     AsyncStatus
+		direction  string
+		state      string
+		message    *string
+		finishedAt *time.Time
+	)
+	err = conn.QueryRow(ctx, "SELECT version, direction, state, error, started_at, finished_at FROM "+driver.asyncTable()+" WHERE id = $1", driver.queryArgs(int64(handle))...).
+		Scan(&status.ID, &direction, &state, &message, &status.StartedAt, &finishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("unknown asynchronous migration %d", handle)
+	}
+	if err != nil {
+		return nil, closedErr(conn, err)
+	}
+
+	status.Direction = m.Up
+	if direction == m.Down.String() {
+		status.Direction = m.Down
+	}
+	status.State = AsyncState(state)
+	if message != nil {
+		status.Error = *message
+	}
+	if finishedAt != nil {
+		status.FinishedAt = *finishedAt
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// envParams maps the libpq environment variables passed as DSN parameters to
+// the parameter names.
+var envParams = []struct{ env, param string }{
+	{"PGSSLMODE", "sslmode"},
+	{"PGSSLROOTCERT", "sslrootcert"},
+	{"PGSSLCERT", "sslcert"},
+	{"PGSSLKEY", "sslkey"},
+	{"PGCONNECT_TIMEOUT", "connect_timeout"},
+	{"PGAPPNAME", "application_name"},
+}
+
+// DSNFromEnv builds a DSN, suitable for New, from the standard libpq
+// environment variables: PGHOST, PGPORT, PGUSER, PGPASSWORD and PGDATABASE,
+// along with PGSSLMODE, PGSSLROOTCERT, PGSSLCERT, PGSSLKEY,
+// PGCONNECT_TIMEOUT and PGAPPNAME if set. PGHOST and PGUSER are required;
+// without PGDATABASE, the server connects to the database named after the
+// user. Empty variables count as unset.
+func DSNFromEnv() (string, error) {
+	var missing []string
+	for _, env := range []string{"PGHOST", "PGUSER"} {
+		if os.Getenv(env) == "" {
+			missing = append(missing, env)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("cannot build a postgres DSN from the environment: %s must be set", strings.Join(missing, " and "))
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Path:   "/" + os.Getenv("PGDATABASE"),
+		User:   url.User(os.Getenv("PGUSER")),
+	}
+	if password := os.Getenv("PGPASSWORD"); password != "" {
+		dsn.User = url.UserPassword(os.Getenv("PGUSER"), password)
+	}
+
+	query := url.Values{}
+
+	// A host starting with a slash is the directory of a Unix socket, which
+	// cannot be part of the URL's authority.
+	host, port := os.Getenv("PGHOST"), os.Getenv("PGPORT")
+	switch {
+	case strings.HasPrefix(host, "/"):
+		query.Set("host", host)
+		if port != "" {
+			query.Set("port", port)
+		}
+	case port != "":
+		dsn.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		dsn.Host = "[" + host + "]"
+	default:
+		dsn.Host = host
+	}
+
+	for _, p := range envParams {
+		if value := os.Getenv(p.env); value != "" {
+			query.Set(p.param, value)
+		}
+	}
+	dsn.RawQuery = query.Encode()
+
+	return dsn.String(), nil
+}
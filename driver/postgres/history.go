@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// HistoryEntry records a migration being applied or rolled back.
+type HistoryEntry struct {
+	ID        string
+	Direction m.Direction
+	AppliedAt time.Time
+}
+
+// historyTable is the name of the history table.
+func (driver *Driver) historyTable() string {
+	return driver.tableName + "_history"
+}
+
+// historyArchiveTable is the name of the table ArchiveHistory moves rows to.
+func (driver *Driver) historyArchiveTable() string {
+	return driver.historyTable() + "_archive"
+}
+
+func (driver *Driver) createHistoryTable(ctx context.Context, q querier) error {
+	_, err := q.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.historyTable()+" (id bigserial not null primary key, version text not null, direction text not null, applied_at timestamptz not null)")
+	return err
+}
+
+// recordHistory appends migration to the history table. Without a clock, the
+// server's time is recorded.
+func (driver *Driver) recordHistory(ctx context.Context, q querier, migration *m.PlannedMigration, appliedAt *time.Time) error {
+	_, err := q.Exec(ctx, "INSERT INTO "+driver.historyTable()+" (version, direction, applied_at) VALUES ($1, $2, COALESCE($3, clock_timestamp()))", driver.queryArgs(migration.ID, migration.Direction.String(), appliedAt)...)
+	if err != nil {
+		return fmt.Errorf("error recording migration history: %s", err)
+	}
+	return nil
+}
+
+// History lists every migration applied or rolled back since WithHistory was
+// enabled, oldest first.
+func (driver *Driver) History(ctx context.Context) ([]HistoryEntry, error) {
+	if !driver.history {
+		return nil, errors.New("history is not recorded, use WithHistory to record it")
+	}
+
+	conn, release, err := driver.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := conn.Query(ctx, "SELECT version, direction, applied_at FROM "+driver.historyTable()+" ORDER BY id", driver.queryArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []HistoryEntry
+	for rows.Next() {
+		var (
+			entry     HistoryEntry
+			direction string
+		)
+		if err := rows.Scan(&entry.ID, &direction, &entry.AppliedAt); err != nil {
+			return nil, err
+		}
+		switch direction {
+		case m.Up.String():
+			entry.Direction = m.Up
+		case m.Down.String():
+			entry.Direction = m.Down
+		default:
+			return nil, fmt.Errorf("unknown direction %q recorded for migration %s", direction, entry.ID)
+		}
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+// ArchiveHistory moves the history entries recorded before the given time to
+// the schema_migration_history_archive table, creating it if necessary, and
+// returns the number of entries moved. It keeps the history table small on
+// installs that apply migrations often; History only lists entries that have
+// not been archived. The version table is not affected.
+//
+// The archive table has the same columns as the history table. It can be
+// created beforehand, for example as a table partitioned by applied_at, in
+// which case ArchiveHistory uses it as is.
+func (driver *Driver) ArchiveHistory(ctx context.Context, before time.Time) (int, error) {
+	if !driver.history {
+		return 0, errors.New("history is not recorded, use WithHistory to record it")
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var archived int
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.historyArchiveTable()+" (id bigint not null, version text not null, direction text not null, applied_at timestamptz not null)")
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, "WITH archived AS (DELETE FROM "+driver.historyTable()+" WHERE applied_at < $1 RETURNING id, version, direction, applied_at) "+
+			"INSERT INTO "+driver.historyArchiveTable()+" (id, version, direction, applied_at) SELECT id, version, direction, applied_at FROM archived", driver.queryArgs(before)...)
+		if err != nil {
+			return err
+		}
+		archived = int(tag.RowsAffected())
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error archiving migration history: %s", err)
+	}
+
+	return archived, nil
+}
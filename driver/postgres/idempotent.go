@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/muxinc/migration/parser"
+)
+
+var (
+	// createGuardRegex matches the DDL that accepts IF NOT EXISTS right after
+	// the object type.
+	createGuardRegex = regexp.MustCompile(`(?i)^CREATE\s+(?:(?:UNLOGGED\s+)?TABLE|(?:UNIQUE\s+)?INDEX(?:\s+CONCURRENTLY)?|SCHEMA|SEQUENCE|EXTENSION)\s+`)
+
+	// dropGuardRegex matches the DDL that accepts IF EXISTS right after the
+	// object type.
+	dropGuardRegex = regexp.MustCompile(`(?i)^DROP\s+(?:TABLE|INDEX(?:\s+CONCURRENTLY)?|(?:MATERIALIZED\s+)?VIEW|SCHEMA|SEQUENCE|TYPE|EXTENSION|FUNCTION|TRIGGER)\s+`)
+
+	// guardedRegex matches what follows the object type of DDL that must be
+	// left untouched: DDL that is already guarded, and indexes without a
+	// name, which can't be guarded.
+	guardedRegex = regexp.MustCompile(`(?i)^(?:IF\s+(?:NOT\s+)?EXISTS|ON)\b`)
+)
+
+// idempotentStatements rewrites the DDL statements in sql, which may contain
+// several statements, into their IF NOT EXISTS and IF EXISTS forms, so that
+// they can be run again on a partially migrated database. Other statements
+// are left untouched.
+func idempotentStatements(sql string) string {
+	var b strings.Builder
+	for _, statement := range parser.SplitStatements(sql) {
+		b.WriteString(idempotentStatement(statement))
+	}
+	return b.String()
+}
+
+func idempotentStatement(statement string) string {
+	start := skipLeadingComments(statement)
+	body := statement[start:]
+
+	for _, rule := range []struct {
+		regex *regexp.Regexp
+		guard string
+	}{
+		{createGuardRegex, "IF NOT EXISTS "},
+		{dropGuardRegex, "IF EXISTS "},
+	} {
+		loc := rule.regex.FindStringIndex(body)
+		if loc == nil {
+			continue
+		}
+		if guardedRegex.MatchString(body[loc[1]:]) {
+			return statement
+		}
+		return statement[:start+loc[1]] + rule.guard + body[loc[1]:]
+	}
+
+	return statement
+}
+
+// skipLeadingComments returns the index of the first character of statement
+// that is neither whitespace nor part of a comment.
+func skipLeadingComments(statement string) int {
+	i := 0
+	for i < len(statement) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(statement[i])):
+			i++
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				return len(statement)
+			}
+			i += end + 1
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i:], "*/")
+			if end < 0 {
+				return len(statement)
+			}
+			i += end + 2
+		default:
+			return i
+		}
+	}
+	return i
+}
@@ -0,0 +1,415 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// defaultLeaseTTL is the ttl of the lease lock when it is used because of
+// transaction pooling rather than WithLeaseLock.
+const defaultLeaseTTL = time.Minute
+
+// advisoryLockPoll is how often Lock retries taking a busy advisory lock.
+const advisoryLockPoll = 250 * time.Millisecond
+
+// leaseTable is the name of the lock table.
+func (driver *Driver) leaseTable() string {
+	return driver.tableName + "_lock"
+}
+
+// leaseLock holds the state of an acquired lease.
+type leaseLock struct {
+	owner string
+	stop  func()
+}
+
+// leaseLost signals that the lease held by Lock was lost. It is closed once,
+// by the goroutine renewing the lease.
+type leaseLost chan struct{}
+
+// lost reports whether the lease was lost. A nil leaseLost is never lost.
+func (l leaseLost) lost() bool {
+	select {
+	case <-l:
+		return true
+	default:
+		return false
+	}
+}
+
+// advisoryLock holds the state of an acquired advisory lock. conn is the
+// connection holding the session-level lock, and release returns it to the
+// pool. Drivers using a single connection leave both unset.
+type advisoryLock struct {
+	conn    *pgx.Conn
+	release func()
+}
+
+// Lock acquires the migration lock: the lease configured with WithLeaseLock
+// or implied by transaction pooling mode, and a session-level advisory lock
+// otherwise. Pool-backed drivers hold a connection for as long as they hold
+// an advisory lock. If WithReconnect replaces the connection holding the
+// advisory lock, the lock is taken again on the new connection, or the call
+// that reconnected fails with ErrLockLost.
+//
+// As advisory locks are re-entrant within a session, Lock returns ErrLockHeld
+// if the driver already holds the lock rather than taking it again.
+func (driver *Driver) Lock(ctx context.Context) error {
+	if err := driver.reserveLock(); err != nil {
+		return err
+	}
+
+	var err error
+	if driver.leaseTTL > 0 {
+		err = driver.lockLease(ctx)
+	} else {
+		err = driver.lockAdvisory(ctx)
+	}
+	if err != nil {
+		driver.releaseLock()
+	}
+	return err
+}
+
+// Unlock releases a lock acquired by Lock.
+func (driver *Driver) Unlock(ctx context.Context) error {
+	if driver.leaseTTL > 0 {
+		return driver.unlockLease(ctx)
+	}
+	return driver.unlockAdvisory(ctx)
+}
+
+// reserveLock marks the lock as held by the driver, so that concurrent calls
+// to Lock on the same driver fail rather than overwrite each other's state.
+func (driver *Driver) reserveLock() error {
+	driver.connMu.Lock()
+	defer driver.connMu.Unlock()
+
+	if driver.locked {
+		return ErrLockHeld
+	}
+	driver.locked = true
+	return nil
+}
+
+// releaseLock clears the lock state set by reserveLock and Lock.
+func (driver *Driver) releaseLock() {
+	driver.connMu.Lock()
+	defer driver.connMu.Unlock()
+
+	driver.locked = false
+	driver.lease = nil
+	driver.advisory = nil
+}
+
+// advisoryLockKey returns the key of the advisory lock, derived from the
+// version table so that namespaces are locked separately.
+func (driver *Driver) advisoryLockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(driver.tableName))
+	return int64(h.Sum64())
+}
+
+// tryAdvisoryLock takes the advisory lock on conn if it is free.
+func (driver *Driver) tryAdvisoryLock(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	var acquired bool
+	err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", driver.queryArgs(driver.advisoryLockKey())...).Scan(&acquired)
+	return acquired, err
+}
+
+// lockAdvisory takes the advisory lock, polling until it is free.
+func (driver *Driver) lockAdvisory(ctx context.Context) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	for {
+		acquired, err := driver.tryAdvisoryLock(ctx, conn)
+		if err != nil {
+			release()
+			return fmt.Errorf("error acquiring migration lock: %s", closedErr(conn, err))
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			release()
+			return &m.LockTimeoutError{Name: driver.tableName, Timeout: time.Since(start)}
+		case <-time.After(advisoryLockPoll):
+		}
+	}
+
+	// The single connection keeps the lock when released, and must be
+	// released for migrations to use it. connMu is held until then.
+	if driver.pool == nil {
+		driver.advisory = &advisoryLock{}
+		release()
+		return nil
+	}
+
+	driver.connMu.Lock()
+	driver.advisory = &advisoryLock{conn: conn, release: release}
+	driver.connMu.Unlock()
+	return nil
+}
+
+func (driver *Driver) unlockAdvisory(ctx context.Context) error {
+	// Cleared first, so that reconnecting below doesn't take the lock again.
+	driver.connMu.Lock()
+	lock := driver.advisory
+	driver.advisory = nil
+	driver.connMu.Unlock()
+	if lock == nil {
+		return nil
+	}
+	defer driver.releaseLock()
+
+	conn := lock.conn
+	if driver.pool == nil {
+		var release func()
+		var err error
+		if conn, release, err = driver.acquire(ctx); err != nil {
+			return err
+		}
+		defer release()
+	} else {
+		defer lock.release()
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", driver.queryArgs(driver.advisoryLockKey())...); err != nil {
+		return fmt.Errorf("error releasing migration lock: %s", err)
+	}
+	return nil
+}
+
+// lockLease takes the lease row of the lock table, polling until it is free or
+// its holder's lease has expired. While held, the lease is renewed in the
+// background so that long migrations don't lose it. If it is lost anyway,
+// because renewing failed until it expired or another run took it over,
+// running migrations are cancelled and fail with ErrLockLost.
+func (driver *Driver) lockLease(ctx context.Context) error {
+	if err := driver.ensureLeaseTableExists(ctx); err != nil {
+		return err
+	}
+
+	owner, err := newLeaseOwner()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	poll := driver.leaseTTL / 10
+	if poll > time.Second {
+		poll = time.Second
+	}
+
+	for {
+		acquired, err := driver.tryLease(ctx, owner)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return &m.LockTimeoutError{Name: driver.leaseTable(), Timeout: time.Since(start)}
+		case <-time.After(poll):
+		}
+	}
+
+	conn, closeConn, err := driver.leaseConn(ctx)
+	if err != nil {
+		driver.deleteLease(ctx, owner)
+		return err
+	}
+
+	lost := make(leaseLost)
+	driver.mu.Lock()
+	driver.leaseLost = lost
+	driver.mu.Unlock()
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer closeConn()
+
+		ticker := time.NewTicker(driver.leaseTTL / 3)
+		defer ticker.Stop()
+
+		renewed := time.Now()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			held, err := driver.renewLease(renewCtx, conn, owner)
+			switch {
+			case renewCtx.Err() != nil:
+				return
+			case err == nil && held:
+				renewed = time.Now()
+				continue
+			case err == nil:
+				driver.logger.Printf("Migration lock lease was taken over by another run")
+			case time.Since(renewed) < driver.leaseTTL:
+				driver.logger.Printf("Unable to renew migration lock lease: %s", err)
+				continue
+			default:
+				driver.logger.Printf("Migration lock lease expired, unable to renew it: %s", err)
+			}
+
+			close(lost)
+			return
+		}
+	}()
+
+	driver.connMu.Lock()
+	driver.lease = &leaseLock{
+		owner: owner,
+		stop: func() {
+			cancel()
+			<-done
+		},
+	}
+	driver.connMu.Unlock()
+
+	return nil
+}
+
+// leaseConn returns the connection renewing the lease, and a function closing
+// it. Drivers using a single connection hold it for the whole duration of a
+// migration, so the lease is renewed on a connection of its own, set up like
+// the driver's connection. Pool-backed drivers return no connection, and renew
+// the lease on connections acquired from the pool.
+func (driver *Driver) leaseConn(ctx context.Context) (*pgx.Conn, func(), error) {
+	if driver.pool != nil {
+		return nil, func() {}, nil
+	}
+
+	driver.connMu.Lock()
+	config := driver.connConfig
+	if config == nil {
+		config = driver.conn.Config()
+	}
+	driver.connMu.Unlock()
+
+	conn, err := pgx.ConnectConfig(ctx, config.Copy())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to renew the migration lock: %w", err)
+	}
+
+	if driver.afterConnect != nil {
+		if err := driver.afterConnect(ctx, conn); err != nil {
+			conn.Close(ctx)
+			return nil, nil, fmt.Errorf("error running after connect hook: %w", err)
+		}
+	}
+
+	return conn, func() { conn.Close(context.Background()) }, nil
+}
+
+func (driver *Driver) unlockLease(ctx context.Context) error {
+	driver.connMu.Lock()
+	lease := driver.lease
+	driver.lease = nil
+	driver.connMu.Unlock()
+	if lease == nil {
+		return nil
+	}
+	defer driver.releaseLock()
+
+	lease.stop()
+
+	driver.mu.Lock()
+	driver.leaseLost = nil
+	driver.mu.Unlock()
+
+	return driver.deleteLease(ctx, lease.owner)
+}
+
+// deleteLease releases the lease of owner, if it still holds it.
+func (driver *Driver) deleteLease(ctx context.Context, owner string) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if _, err := conn.Exec(ctx, "DELETE FROM "+driver.leaseTable()+" WHERE id = 1 AND owner = $1", driver.queryArgs(owner)...); err != nil {
+		return fmt.Errorf("error releasing migration lock: %s", err)
+	}
+	return nil
+}
+
+func (driver *Driver) ensureLeaseTableExists(ctx context.Context) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.leaseTable()+" (id integer not null primary key, owner text not null, expires_at timestamptz not null)")
+	return err
+}
+
+// tryLease takes the lease if nobody holds it or the current lease expired.
+func (driver *Driver) tryLease(ctx context.Context, owner string) (bool, error) {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	tag, err := conn.Exec(ctx, "INSERT INTO "+driver.leaseTable()+" AS l (id, owner, expires_at) VALUES (1, $1, now() + $2 * interval '1 millisecond') "+
+		"ON CONFLICT (id) DO UPDATE SET owner = EXCLUDED.owner, expires_at = EXCLUDED.expires_at WHERE l.expires_at < now()",
+		driver.queryArgs(owner, driver.leaseTTL.Milliseconds())...)
+	if err != nil {
+		return false, fmt.Errorf("error acquiring migration lock: %s", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// renewLease extends the lease of owner on conn, or on a connection of the
+// pool if conn is nil. It reports whether owner still held the lease.
+func (driver *Driver) renewLease(ctx context.Context, conn *pgx.Conn, owner string) (bool, error) {
+	if conn == nil {
+		pooled, err := driver.pool.Acquire(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer pooled.Release()
+		conn = pooled.Conn()
+	}
+
+	tag, err := conn.Exec(ctx, "UPDATE "+driver.leaseTable()+" SET expires_at = now() + $2 * interval '1 millisecond' WHERE id = 1 AND owner = $1", driver.queryArgs(owner, driver.leaseTTL.Milliseconds())...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func newLeaseOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
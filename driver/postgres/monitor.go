@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// monitorBlocking starts watching the backend of conn for other sessions
+// blocking it, logging each blocker every blockingMonitorInterval. The
+// returned function stops the monitor and waits for it to exit. If the monitor
+// is disabled, the returned function does nothing.
+//
+// Blockers are queried over a separate connection, which is only opened once
+// the first interval has elapsed so that quick migrations don't pay for it.
+func (driver *Driver) monitorBlocking(ctx context.Context, conn *pgx.Conn) func() {
+	if driver.blockingMonitorInterval <= 0 {
+		return func() {}
+	}
+
+	pid := conn.PgConn().PID()
+	config := conn.Config()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(driver.blockingMonitorInterval)
+		defer ticker.Stop()
+
+		var monitorConn *pgx.Conn
+		defer func() {
+			if monitorConn != nil {
+				monitorConn.Close(context.Background())
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if monitorConn == nil {
+				var err error
+				if monitorConn, err = pgx.ConnectConfig(ctx, config); err != nil {
+					if ctx.Err() == nil {
+						driver.logger.Printf("Unable to connect to monitor blocking sessions: %s", err)
+					}
+					return
+				}
+			}
+
+			if err := driver.logBlockers(ctx, monitorConn, pid); err != nil && ctx.Err() == nil {
+				driver.logger.Printf("Unable to query blocking sessions: %s", err)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// logBlockers logs the sessions blocking the backend with the given pid.
+func (driver *Driver) logBlockers(ctx context.Context, conn *pgx.Conn, pid uint32) error {
+	rows, err := conn.Query(ctx, "SELECT pid, coalesce(query, '') FROM pg_stat_activity WHERE pid = ANY(pg_blocking_pids($1))", driver.queryArgs(int32(pid))...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			blockingPID int32
+			query       string
+		)
+		if err := rows.Scan(&blockingPID, &query); err != nil {
+			return err
+		}
+		driver.logger.Printf("Migration on backend %d is blocked by backend %d running: %s", pid, blockingPID, query)
+	}
+
+	return rows.Err()
+}
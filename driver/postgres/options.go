@@ -0,0 +1,413 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// Option configures a Driver.
+type Option func(*Driver)
+
+// WithAfterConnect registers a function that is run on the connection right
+// after it has been established, before the version table is created and
+// before any migrations run. It is useful for session setup such as SET ROLE or
+// setting GUCs required by row-level security policies.
+//
+// If fn returns an error, creating the driver fails.
+func WithAfterConnect(fn func(ctx context.Context, conn *pgx.Conn) error) Option {
+	return func(d *Driver) {
+		d.afterConnect = fn
+	}
+}
+
+// WithBeforeDDL registers a function that is run before the statements of
+// each migration containing DDL, such as CREATE, ALTER or DROP, are run, in
+// either direction. It runs on the migration's connection, within its
+// transaction if it uses one, so that it can adjust the database around the
+// schema change, for example updating the tables of a logical replication
+// publication with ALTER PUBLICATION. Unlike the post-hook of a migration, it
+// applies to every migration run by the driver.
+//
+// If fn returns an error, the migration fails without running.
+func WithBeforeDDL(fn func(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) error) Option {
+	return func(d *Driver) {
+		d.beforeDDL = fn
+	}
+}
+
+// WithAfterDDL registers a function that is run after the statements of each
+// migration containing DDL succeeded, before its version is recorded, on the
+// same connection and transaction as WithBeforeDDL.
+//
+// If fn returns an error, the migration fails: a migration run in a
+// transaction is rolled back, while the statements of one that doesn't use a
+// transaction stay applied without its version being recorded.
+func WithAfterDDL(fn func(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) error) Option {
+	return func(d *Driver) {
+		d.afterDDL = fn
+	}
+}
+
+// WithMaxConns sets the maximum number of connections of the pool created by
+// NewPool. It has no effect on drivers created from a single connection.
+//
+// Each migration holds one connection for its duration. Locks held for a whole
+// migration run, such as session-level advisory locks, pin an additional
+// connection, so the pool must be large enough to accommodate both.
+func WithMaxConns(n int) Option {
+	return func(d *Driver) {
+		d.maxConns = int32(n)
+	}
+}
+
+// WithMinConns sets the minimum number of idle connections kept open by the
+// pool created by NewPool. It has no effect on drivers created from a single
+// connection.
+func WithMinConns(n int) Option {
+	return func(d *Driver) {
+		d.minConns = int32(n)
+	}
+}
+
+// WithVersionColumnType sets the SQL type of the version column, for example
+// "text" to allow IDs longer than the default varchar(255). Supported types
+// are varchar(255), varchar(512), varchar(1024) and text; creating the driver
+// fails for any other value.
+//
+// The column is created with the "C" collation, so that versions are stored and
+// compared as exact bytes, like migration.CompareIDs does: IDs differing only
+// in case, such as V1_Foo and v1_foo, are distinct versions, whatever the
+// default collation of the database.
+//
+// The type is only used when the version table is created, so existing version
+// tables keep their current type.
+func WithVersionColumnType(columnType string) Option {
+	return func(d *Driver) {
+		d.versionColumnType = columnType
+	}
+}
+
+// WithBlockingMonitor enables a background monitor that, while a migration is
+// running, checks every interval whether other sessions are blocking it, for
+// example by holding a conflicting lock. Each blocking session is logged with
+// its PID and current query so that operators can intervene rather than wait
+// for statement_timeout. The monitor uses its own connection and stops as soon
+// as the migration completes.
+//
+// Messages are logged to the logger set with WithLogger, or the standard
+// logger if none is set.
+func WithBlockingMonitor(interval time.Duration) Option {
+	return func(d *Driver) {
+		d.blockingMonitorInterval = interval
+	}
+}
+
+// WithLogger sets the logger the driver reports to, for example from the
+// monitor enabled with WithBlockingMonitor.
+func WithLogger(l m.Logger) Option {
+	return func(d *Driver) {
+		d.logger = l
+	}
+}
+
+// WithClock sets the function used to determine the time recorded when a
+// migration is applied. By default, the database server's clock_timestamp()
+// is recorded, which unlike now() increases between the migrations applied in
+// a single transaction, so that Versions keeps their order. It is mainly
+// useful to get deterministic timestamps in tests.
+func WithClock(clock func() time.Time) Option {
+	return func(d *Driver) {
+		d.clock = clock
+	}
+}
+
+// WithNotifyChannel makes the driver issue a NOTIFY on channel for each
+// migration it applies or rolls back, with a payload of the form
+// "<id>:<direction>", for example "1_init:up". Listeners can use it to reload
+// configuration or clear caches. The notification is sent in the same
+// transaction as the version update, so it is only delivered if the migration
+// is committed.
+func WithNotifyChannel(channel string) Option {
+	return func(d *Driver) {
+		d.notifyChannel = channel
+	}
+}
+
+// WithVerificationConn makes the driver read the applied versions, for example
+// for Versions, AppliedMigrations and migration.Status, from conn instead of
+// the connection used to apply migrations. conn can point to a read-only
+// replica to reduce load on the primary. It is not closed by the driver.
+//
+// Replicas may lag behind the primary, so reads can miss recently applied
+// migrations. As migration.Migrate plans with Versions, a lagging replica can
+// cause already applied migrations to be planned again; run migrations with a
+// driver that doesn't use this option if that is a concern.
+func WithVerificationConn(conn *pgx.Conn) Option {
+	return func(d *Driver) {
+		d.verificationConn = conn
+	}
+}
+
+// WithVersionConflict sets what happens when a migration is applied whose
+// version is already recorded. By default, VersionConflictError fails the
+// migration with an AlreadyAppliedError before its statements run. Other
+// strategies only affect recording the version: the statements of the
+// migration run regardless, so they should be idempotent.
+func WithVersionConflict(strategy VersionConflictStrategy) Option {
+	return func(d *Driver) {
+		d.versionConflict = strategy
+	}
+}
+
+// WithStoreSQL records the statements of each applied migration in the sql
+// column of the version table, for auditing exactly what was run. The full
+// text of every migration is stored, so the version table grows by roughly the
+// size of the migration files.
+//
+// Rolling back a migration removes its row, including the stored SQL.
+func WithStoreSQL() Option {
+	return func(d *Driver) {
+		d.storeSQL = true
+	}
+}
+
+// WithSequenceOrder adds a seq bigserial column to the version table,
+// numbering migrations in the order they are applied, and makes Versions
+// order by it. Rollbacks then undo migrations in exactly the reverse of the
+// order they were applied, without relying on applied_at, which a clock set
+// with WithClock may freeze, or on the collation of IDs. Versions recorded
+// before the column is added are numbered by applied_at.
+func WithSequenceOrder() Option {
+	return func(d *Driver) {
+		d.sequenceOrder = true
+	}
+}
+
+// WithoutVersionTracking makes Migrate run the statements of migrations without
+// recording or removing their versions, so Versions and AppliedMigrations
+// always report that nothing is applied. It is meant for test fixtures and
+// throwaway databases only: as nothing is tracked, running migration.Migrate
+// twice applies every migration again, and migrations can't be rolled back.
+// History, notifications and RecordVersions are skipped as well.
+func WithoutVersionTracking() Option {
+	return func(d *Driver) {
+		d.untracked = true
+	}
+}
+
+// WithPerStatementTimeout bounds each statement of a migration, whether it
+// runs in a transaction or not, to timeout. A statement running for longer is
+// cancelled and the migration fails with a StatementTimeoutError naming the
+// statement. This is finer-grained than a deadline on the context passed to
+// Migrate, which bounds the migration as a whole.
+//
+// The timeout is enforced by the server through statement_timeout, so the
+// connection stays usable after a statement is cancelled. The session's own
+// statement_timeout is restored once the migration completes. Statements of a
+// non-transactional migration that completed before the timeout stay applied.
+func WithPerStatementTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.statementTimeout = timeout
+	}
+}
+
+// WithLockTimeout makes each migration fail with a LockTimeoutError if it
+// waits for longer than timeout to acquire a lock, such as the lock ALTER
+// TABLE needs on a table another session is using, rather than queueing
+// behind it, and making every later query on the table queue behind the
+// migration. Unlike WithPerStatementTimeout, time spent running once locks
+// are acquired is not bounded.
+//
+// The lock_timeout setting is set for the transaction of transactional
+// migrations, and for the session around non-transactional ones.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.lockTimeout = timeout
+	}
+}
+
+// WithPreparedStatements prepares the statements that list, record and
+// remove versions once per connection, rather than relying on the statement
+// cache of the connection's query execution mode. This saves parsing them on
+// every call, which matters when Versions is called often on a busy pool, even
+// when the connection is configured with a mode that does not cache
+// statements.
+func WithPreparedStatements() Option {
+	return func(d *Driver) {
+		d.prepareStatements = true
+	}
+}
+
+// WithHistory appends a row to the schema_migration_history table each time a
+// migration is applied or rolled back, in the same transaction as the change
+// to the version table. The version table remains authoritative for which
+// migrations are applied; the history keeps an audit trail of rollbacks,
+// which is read using History.
+func WithHistory() Option {
+	return func(d *Driver) {
+		d.history = true
+	}
+}
+
+// WithReconnect makes a driver created by New or NewWithConfig reconnect when
+// its connection has been closed, for example by a network failure or a
+// server restart. The next call opens a new connection, running the
+// WithAfterConnect hook on it, and reading versions is retried once.
+// Migrations are never retried, as a migration interrupted by the connection
+// closing may have been partially applied.
+//
+// Without this option, calls on a closed connection return a
+// ConnectionClosedError. Pool-backed drivers replace closed connections
+// regardless.
+func WithReconnect() Option {
+	return func(d *Driver) {
+		d.reconnect = true
+	}
+}
+
+// WithDefaultRole runs the statements of each migration as role, using SET
+// LOCAL ROLE within the migration's transaction or SET ROLE otherwise, so that
+// the objects they create are owned by role rather than by the connecting
+// user. The role is reset before the version is recorded, so role needs no
+// access to the version table. The connecting user must be a member of role.
+//
+// role must be an unquoted identifier; other names are rejected when the
+// driver is created.
+func WithDefaultRole(role string) Option {
+	return func(d *Driver) {
+		d.role = role
+	}
+}
+
+// WithNamespace keeps the versions of the migration set in its own version
+// table, named schema_migration_<namespace>, so that several independent
+// migration sets, such as a core schema and plugin schemas, can be applied to
+// the same database. Each namespace is planned separately, using a driver per
+// namespace. The history and lock tables are separated the same way.
+//
+// namespace may only contain lowercase letters, digits and underscores.
+func WithNamespace(namespace string) Option {
+	return func(d *Driver) {
+		d.namespace = namespace
+	}
+}
+
+// PoolingMode is the pooling mode of a connection pooler, such as PgBouncer,
+// between the driver and the database.
+type PoolingMode int
+
+const (
+	// PoolingSession is used when each client keeps its server connection
+	// for the whole session, as when connecting directly to the database.
+	// It is the default.
+	PoolingSession PoolingMode = iota
+
+	// PoolingTransaction is used when clients only keep a server connection
+	// for the duration of a transaction, as with PgBouncer's transaction
+	// pooling.
+	PoolingTransaction
+)
+
+// WithPoolingMode tells the driver how connections are pooled. In
+// PoolingTransaction mode, the driver avoids features tied to a server
+// session, which a pooler may hand to another client between transactions:
+//
+//   - Lock uses the table-based lease lock of WithLeaseLock, with a default
+//     ttl of one minute, rather than a session-level advisory lock.
+//   - Queries use the simple protocol rather than the extended protocol, so no
+//     statements are prepared or cached. WithPreparedStatements is refused.
+//
+// The trade-offs are that a crashed run holds the lock until the lease
+// expires, and that queries with parameters are interpolated client-side.
+// Other session state doesn't survive across transactions either, so
+// non-transactional migrations must not rely on SET, and WithDefaultRole only
+// applies reliably to transactional migrations.
+func WithPoolingMode(mode PoolingMode) Option {
+	return func(d *Driver) {
+		d.poolingMode = mode
+	}
+}
+
+// WithLeaseLock makes Lock serialize migration runs using a lease stored in a
+// row of the schema_migration_lock table, rather than advisory locks. This
+// works on PostgreSQL-compatible databases without pg_advisory_lock, such as
+// CockroachDB.
+//
+// The lease is renewed while held, on a connection separate from the one
+// running migrations: drivers using a single connection open one for as long
+// as they hold the lease. If its holder crashes, the lease expires after ttl
+// and can be taken over by another run, so ttl should comfortably exceed the
+// time renewals may be delayed by. If the lease is lost anyway, running
+// migrations are cancelled and fail with ErrLockLost.
+func WithLeaseLock(ttl time.Duration) Option {
+	return func(d *Driver) {
+		d.leaseTTL = ttl
+	}
+}
+
+// WithIdempotentGuards rewrites the DDL of migrations into forms that succeed
+// when run again, so that migrations can be re-run on a partially migrated
+// database, for example after a crash during a non-transactional migration:
+// CREATE TABLE, INDEX, SCHEMA, SEQUENCE and EXTENSION get IF NOT EXISTS, and
+// DROP of tables, indexes, views and other objects gets IF EXISTS. Statements
+// that are already guarded, indexes without a name and any other statement,
+// such as ALTER TABLE, are run as written.
+//
+// IF NOT EXISTS only checks the name of the object, so an existing object
+// with a different definition is silently kept.
+func WithIdempotentGuards() Option {
+	return func(d *Driver) {
+		d.idempotentGuards = true
+	}
+}
+
+// WithSchemaPrefix qualifies the unqualified table references of migrations
+// with schema, so that the same migrations can be applied to the schema of
+// each tenant without relying on search_path. For example,
+// "CREATE TABLE users" runs as "CREATE TABLE schema.users", while references
+// that are already qualified are left untouched.
+//
+// The rewrite is best-effort: only the tables created, altered, dropped or
+// written to by a statement, the tables of indexes and those named in
+// REFERENCES clauses are qualified, and only the first table of a list. Tables
+// read in FROM or JOIN clauses, tables named by triggers, and anything within
+// string literals or dollar-quoted strings, such as function bodies and
+// dynamic SQL, are not. The version table is not affected.
+func WithSchemaPrefix(schema string) Option {
+	return func(d *Driver) {
+		d.schemaPrefix = schema
+	}
+}
+
+// VersionColumn declares an extra column of the version table, filled in by
+// the builder passed to WithVersionRowBuilder.
+type VersionColumn struct {
+	// Name is the name of the column. It must be a lower case identifier
+	// that needs no quoting, and can't be one of the driver's own columns.
+	Name string
+	// Type is the SQL type the column is created with, such as "text",
+	// "varchar(40)" or "integer". Only a type name is accepted, without
+	// defaults or constraints.
+	Type string
+}
+
+// WithVersionRowBuilder stores extra columns, such as the git commit or the
+// ticket of a migration, in the version table. The columns are added to the
+// version table if missing, and build is called for each applied migration to
+// return their values, keyed by column name. Columns build leaves out are
+// recorded as NULL, and returning a key that isn't declared in columns fails
+// the migration. Values are passed as query parameters, so they must be
+// encodable into the type of their column.
+//
+// The values are recorded along with the version and removed with it when
+// the migration is rolled back. With VersionConflictUpdate, they are
+// refreshed when a version is recorded again.
+func WithVersionRowBuilder(columns []VersionColumn, build func(migration *m.PlannedMigration) map[string]interface{}) Option {
+	return func(d *Driver) {
+		d.versionColumns = columns
+		d.versionRowBuilder = build
+	}
+}
@@ -1,25 +1,474 @@
+// Package postgres implements a migration.Driver for PostgreSQL.
+//
+// The driver is built on pgx v5 and only supports its types: connections,
+// configs and pools passed to the constructors must come from
+// github.com/jackc/pgx/v5. pgx v4 types are not interchangeable with them, so
+// applications still on pgx v4 need to open a separate v5 connection for
+// migrations, for example using New with a DSN.
 package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	m "github.com/muxinc/migration"
 	"github.com/muxinc/migration/parser"
 )
 
-// Driver is the postgres migration.Driver implementation
+// Driver is the postgres migration.Driver implementation. It is safe for
+// concurrent use: calls sharing a single connection are serialized.
 type Driver struct {
+	// Exactly one of conn and pool is set. Use acquire to get a connection
+	// to work with.
 	conn *pgx.Conn
-	// closeConnOnClose indicates whether or not conn should be closed upon
-	// Driver.Close(). It is set to true if the conn was created by the Driver
-	// rather than passed in.
+	pool *pgxpool.Pool
+	// closeConnOnClose indicates whether or not conn (or pool) should be
+	// closed upon Driver.Close(). It is set to true if the conn was created by
+	// the Driver rather than passed in.
 	closeConnOnClose bool
+
+	// connMu serializes use of conn, as a *pgx.Conn is not safe for
+	// concurrent use. Connections acquired from pool need no locking.
+	connMu sync.Mutex
+
+	// verificationConn, if set, is used instead of conn or pool for reading
+	// the applied versions. verificationConnMu serializes its use.
+	verificationConn   *pgx.Conn
+	verificationConnMu sync.Mutex
+
+	// connConfig is the configuration conn was created with, used to
+	// reconnect when reconnect is set. It is only set when the driver
+	// created conn.
+	connConfig *pgx.ConnConfig
+	reconnect  bool
+
+	afterConnect func(ctx context.Context, conn *pgx.Conn) error
+	maxConns     int32
+	minConns     int32
+
+	// beforeDDL and afterDDL run around the statements of migrations that
+	// change the schema, if set.
+	beforeDDL func(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) error
+	afterDDL  func(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) error
+
+	// blockingMonitorInterval enables logging sessions that block a running
+	// migration to logger when positive.
+	blockingMonitorInterval time.Duration
+	logger                  m.Logger
+
+	// notifyChannel is the channel notified of each applied migration, if
+	// set.
+	notifyChannel string
+
+	// versionConflict determines what happens when recording a version that
+	// is already recorded.
+	versionConflict VersionConflictStrategy
+
+	// storeSQL enables recording the statements of applied migrations.
+	storeSQL bool
+
+	// sequenceOrder enables recording the order migrations are applied in
+	// the seq column of the version table, which Versions orders by.
+	sequenceOrder bool
+
+	// versionColumns are the extra columns of the version table, filled in
+	// by versionRowBuilder.
+	versionColumns    []VersionColumn
+	versionRowBuilder func(migration *m.PlannedMigration) map[string]interface{}
+
+	// untracked disables reading and writing the version table.
+	untracked bool
+
+	// statementTimeout bounds each statement of a migration when positive.
+	statementTimeout time.Duration
+
+	// lockTimeout bounds waiting for each lock taken by a migration when
+	// positive.
+	lockTimeout time.Duration
+
+	// idempotentGuards enables rewriting DDL into IF NOT EXISTS and IF EXISTS
+	// forms.
+	idempotentGuards bool
+
+	// schemaPrefix is the schema unqualified table references of migrations
+	// are qualified with, if set.
+	schemaPrefix string
+
+	// role is the role migrations run as, if set.
+	role string
+
+	// namespace separates the versions of independent migration sets applied
+	// to the same database. tableName is the version table of the namespace.
+	namespace string
+	tableName string
+
+	// history enables appending each applied and rolled back migration to
+	// the history table.
+	history bool
+
+	// prepareStatements enables preparing the statements that read and
+	// update the version table once per connection.
+	prepareStatements bool
+
+	// poolingMode is the pooling mode of the connection pooler in front of
+	// the database, if any.
+	poolingMode PoolingMode
+
+	// leaseTTL enables the lease lock used by Lock when positive. Otherwise,
+	// Lock takes an advisory lock. locked is set while Lock holds the lock or
+	// is acquiring it, and lease and advisory are the currently held lease or
+	// advisory lock, if any. connMu guards all three.
+	leaseTTL time.Duration
+	locked   bool
+	lease    *leaseLock
+	advisory *advisoryLock
+
+	// clock returns the time recorded as applied_at. If nil, the server's
+	// clock_timestamp() is used.
+	clock func() time.Time
+
+	// versionColumnType is the SQL type of the version column used when
+	// creating the version table.
+	versionColumnType string
+
+	// mu guards shuttingDown, abort, aborted and leaseLost. inFlight tracks
+	// running migrations so that Shutdown can wait for them, and closing
+	// abort cancels them, after which aborted is set. leaseLost is set while
+	// Lock holds a lease, and cancels running migrations when the lease is
+	// lost.
+	mu           sync.Mutex
+	shuttingDown bool
+	inFlight     sync.WaitGroup
+	abort        chan struct{}
+	aborted      bool
+	leaseLost    leaseLost
 }
 
+// postgresTableName is the name of the version table. Drivers with a
+// namespace suffix it with the namespace.
 const postgresTableName = "schema_migration"
 
+const defaultVersionColumnType = "varchar(255)"
+
+// versionColumnTypes are the types allowed for the version column, mapped to
+// their information_schema data types. The type is interpolated into DDL, so
+// only these exact values are accepted.
+var versionColumnTypes = map[string]string{
+	"varchar(255)":  "character varying",
+	"varchar(512)":  "character varying",
+	"varchar(1024)": "character varying",
+	"text":          "text",
+}
+
+// roleRegex matches the role names accepted by WithDefaultRole: unquoted
+// PostgreSQL identifiers.
+var roleRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+
+// namespaceRegex matches the namespaces accepted by WithNamespace. Namespaces
+// become part of table names, so they are limited to characters that need no
+// quoting.
+var namespaceRegex = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// versionColumnRegex matches the names of the extra version table columns
+// accepted by WithVersionRowBuilder, which need no quoting.
+var versionColumnRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// versionColumnTypeRegex matches the types accepted for the extra version
+// table columns of WithVersionRowBuilder: a type name, optionally qualified by
+// a schema, or one of the multi-word type names, with optional type modifiers,
+// time zone and array bounds, such as "text", "varchar(40)", "numeric(10, 2)",
+// "timestamp(3) with time zone" or "integer[]". Anything else, such as
+// semicolons, quotes, comments, defaults and constraints, is refused.
+var versionColumnTypeRegex = regexp.MustCompile(`(?i)^` +
+	`(?:[a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?|double precision|(?:character|bit) varying)` +
+	`(?: ?\(\d+(?:, ?\d+)?\))?` +
+	`(?: with(?:out)? time zone)?` +
+	`(?:\[\d*\])*$`)
+
+// reservedVersionColumns are the columns of the version table managed by the
+// driver, which WithVersionRowBuilder can't declare.
+var reservedVersionColumns = map[string]bool{
+	"version": true, "metadata": true, "applied_at": true, "sql": true, "checksum": true, "seq": true,
+}
+
+// nonTransactionalRegex matches statements that cannot run inside a
+// transaction block, ignoring leading comments.
+var nonTransactionalRegex = regexp.MustCompile(`(?is)^\s*(?:--[^\n]*\n\s*)*(?:` +
+	`CREATE\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY|DROP\s+INDEX\s+CONCURRENTLY|` +
+	`REINDEX\s+(?:\([^)]*\)\s*)?\w+\s+CONCURRENTLY|VACUUM|ALTER\s+SYSTEM|` +
+	`(?:CREATE|DROP)\s+(?:DATABASE|TABLESPACE))\b`)
+
+// unvalidatableRegex matches the statements ValidateStatements skips, ignoring
+// leading comments, because PL/pgSQL reads them differently than plain SQL:
+// transaction control, which it rejects or reads as the start of a block,
+// EXECUTE and cursor commands, which it reads as its own statements, DO blocks
+// and function definitions, whose bodies it doesn't expect, and SELECT ...
+// INTO, which it reads as assigning variables.
+var unvalidatableRegex = regexp.MustCompile(`(?is)^\s*(?:--[^\n]*\n\s*)*(?:` +
+	`BEGIN|START\s+TRANSACTION|COMMIT|END|ROLLBACK|ABORT|SAVEPOINT|RELEASE|PREPARE\s+TRANSACTION|` +
+	`EXECUTE|DECLARE|FETCH|MOVE|CLOSE|DO|` +
+	`CREATE\s+(?:OR\s+REPLACE\s+)?(?:FUNCTION|PROCEDURE)|` +
+	`(?:SELECT|WITH)\b.*\bINTO)\b`)
+
+// ddlRegex matches statements that change the schema, ignoring leading
+// comments.
+var ddlRegex = regexp.MustCompile(`(?is)^\s*(?:--[^\n]*\n\s*)*(?:CREATE|ALTER|DROP|TRUNCATE|COMMENT\s+ON)\b`)
+
+// containsDDL reports whether any statement of parsed changes the schema.
+func containsDDL(parsed *parser.ParsedMigration) bool {
+	for _, block := range parsed.Statements {
+		for _, statement := range parser.SplitStatements(block) {
+			if ddlRegex.MatchString(statement) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrShutdown is returned by Migrate once Shutdown has been called.
+var ErrShutdown = errors.New("driver is shutting down")
+
+// ErrLockHeld is returned by Lock when the driver already holds the migration
+// lock, for example because two runs share the driver.
+var ErrLockHeld = errors.New("migration lock is already held by this driver")
+
+// ErrLockLost is returned when the lock taken by Lock was lost while held:
+// either WithReconnect replaced the connection holding the advisory lock and
+// another run took the lock in the meantime, or the lease lock couldn't be
+// renewed before it expired or was taken over. Migrations running when the
+// lease is lost are cancelled, and later ones are refused.
+var ErrLockLost = errors.New("migration lock was lost and may be held by another run")
+
+// VersionConflictStrategy determines what happens when a migration is applied
+// whose version is already recorded, for example when retrying a deploy that
+// partially succeeded.
+type VersionConflictStrategy int
+
+const (
+	// VersionConflictError fails the migration with an AlreadyAppliedError
+	// before its statements run. This is the default.
+	VersionConflictError VersionConflictStrategy = iota
+	// VersionConflictIgnore keeps the recorded version as is.
+	VersionConflictIgnore
+	// VersionConflictUpdate refreshes the metadata, applied_at and sql of
+	// the recorded version.
+	VersionConflictUpdate
+)
+
+// onConflict returns the ON CONFLICT clause implementing the strategy.
+func (s VersionConflictStrategy) onConflict() string {
+	switch s {
+	case VersionConflictIgnore:
+		return " ON CONFLICT (version) DO NOTHING"
+	case VersionConflictUpdate:
+		return " ON CONFLICT (version) DO UPDATE SET metadata = EXCLUDED.metadata, applied_at = EXCLUDED.applied_at, sql = EXCLUDED.sql, checksum = EXCLUDED.checksum"
+	default:
+		return ""
+	}
+}
+
+// IncompatibleVersionTableError is returned when the version table already
+// exists but was not created by this driver, for example because another
+// migration tool uses the same table name.
+type IncompatibleVersionTableError struct {
+	Table string
+}
+
+func (e *IncompatibleVersionTableError) Error() string {
+	return fmt.Sprintf("version table %s exists but has no version column, it may belong to another migration tool: rename or drop the table, or migrate its contents into the expected schema", e.Table)
+}
+
+// NonTransactionalStatementError is returned when a migration that runs in a
+// transaction contains a statement PostgreSQL refuses to run in a
+// transaction block, such as CREATE INDEX CONCURRENTLY.
+type NonTransactionalStatementError struct {
+	ID        string
+	Statement string
+}
+
+func (e *NonTransactionalStatementError) Error() string {
+	return fmt.Sprintf("migration %s runs in a transaction but contains a statement that cannot run inside a transaction block: %q; move it to a separate migration marked with -- +migration NoTransaction", e.ID, e.Statement)
+}
+
+// StatementTimeoutError is returned when a statement of a migration runs for
+// longer than the timeout set with WithPerStatementTimeout. Index is the
+// position of the statement within the migration, starting at 0, counting
+// each statement of a block separately.
+type StatementTimeoutError struct {
+	ID      string
+	Index   int
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *StatementTimeoutError) Error() string {
+	return fmt.Sprintf("statement %d of migration %s did not complete within %s: %s", e.Index, e.ID, e.Timeout, e.Err)
+}
+
+func (e *StatementTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// StatementError is returned when a statement of a migration fails. Index is
+// the position of the failing statement within the migration, starting at 0,
+// and Executed the number of statements that completed before it. Blocks of
+// several statements, such as the single block a transactional migration is
+// parsed into, count as that many statements. When the migration runs in a
+// transaction, the executed statements were rolled back.
+type StatementError struct {
+	ID        string
+	Index     int
+	Executed  int
+	Statement string
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("error executing statement %d of migration %s after %d statements succeeded: %s\n%s", e.Index, e.ID, e.Executed, e.Err, e.Statement)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// AlreadyAppliedError is returned when migrating up a migration whose
+// version is already recorded, with the default VersionConflictError
+// strategy. It is returned before any statement runs, for example when
+// Migrate is called without consulting Versions first.
+type AlreadyAppliedError struct {
+	ID string
+}
+
+func (e *AlreadyAppliedError) Error() string {
+	return fmt.Sprintf("migration %s is already applied", e.ID)
+}
+
+// NotAppliedError is returned when migrating down a migration whose version
+// is not recorded. It is returned before any statement runs.
+type NotAppliedError struct {
+	ID string
+}
+
+func (e *NotAppliedError) Error() string {
+	return fmt.Sprintf("migration %s is not applied, so it cannot be rolled back", e.ID)
+}
+
+// CommitError is returned when the statements of a migration run in a
+// transaction succeeded but committing the transaction failed, for example
+// because a deferred constraint was violated or the server went away. The
+// transaction was rolled back, so the migration is not applied and its
+// version was not recorded.
+type CommitError struct {
+	ID  string
+	Err error
+}
+
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("error committing migration %s, it was not applied: %s", e.ID, e.Err)
+}
+
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
+// LockTimeoutError is returned when a migration could not acquire a lock on
+// a database object within the timeout set with WithLockTimeout, usually
+// because another session holds a conflicting lock. Unlike
+// migration.LockTimeoutError, it is not about the lock serializing migration
+// runs.
+type LockTimeoutError struct {
+	ID      string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("migration %s could not acquire a lock within %s: %s", e.ID, e.Timeout, e.Err)
+}
+
+func (e *LockTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionClosedError is returned when the connection to the database has
+// been closed, for example by a network failure or a server restart. Err is
+// the error that revealed it, if any. Use WithReconnect to reconnect
+// automatically.
+type ConnectionClosedError struct {
+	Err error
+}
+
+func (e *ConnectionClosedError) Error() string {
+	if e.Err == nil {
+		return "connection to postgres is closed"
+	}
+	return fmt.Sprintf("connection to postgres is closed: %s", e.Err)
+}
+
+func (e *ConnectionClosedError) Unwrap() error {
+	return e.Err
+}
+
+// closedErr returns err as a ConnectionClosedError if conn was closed, so
+// callers can tell a dead connection apart from other errors.
+func closedErr(conn *pgx.Conn, err error) error {
+	if err != nil && conn.IsClosed() {
+		return &ConnectionClosedError{Err: err}
+	}
+	return err
+}
+
+// queryArgs returns the arguments to pass to pgx to run a query with args. In
+// transaction pooling mode, it asks pgx to use the simple protocol, as
+// statements prepared by the extended protocol live in the server session,
+// which a pooler may swap between queries.
+func (driver *Driver) queryArgs(args ...interface{}) []interface{} {
+	if driver.poolingMode != PoolingTransaction {
+		return args
+	}
+	return append([]interface{}{pgx.QueryExecModeSimpleProtocol}, args...)
+}
+
+// querier is the subset of *pgx.Conn and pgx.Tx used to run statements.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// connOf returns the connection q runs statements on, or nil if q is neither
+// a connection nor a transaction.
+func connOf(q querier) *pgx.Conn {
+	switch q := q.(type) {
+	case *pgx.Conn:
+		return q
+	case pgx.Tx:
+		return q.Conn()
+	}
+	return nil
+}
+
+func init() {
+	factory := func(ctx context.Context, dsn string) (m.Driver, error) {
+		return New(ctx, dsn)
+	}
+	m.Register("postgres", factory)
+	m.Register("postgresql", factory)
+}
+
 // New creates a new Driver and initializes a connection to the database. The
 // context can be used to cancel the connection attempt.
 //
@@ -27,133 +476,1659 @@ const postgresTableName = "schema_migration"
 //
 // If a conn has been created, it will be closed when Close() is called on the
 // returned Driver.
-func New(ctx context.Context, dsn string) (m.Driver, error) {
+func New(ctx context.Context, dsn string, opts ...Option) (m.Driver, error) {
 	conn, err := pgx.Connect(ctx, dsn)
 	if err != nil {
 		return nil, err
 	}
-	d, err := newFromConn(ctx, conn)
+	d, err := newFromConn(ctx, conn, opts)
 	if err != nil {
 		conn.Close(ctx)
 		return nil, err
 	}
 	// ensure that this conn is closed upon Driver.Close():
 	d.closeConnOnClose = true
+	d.connConfig = conn.Config()
 	return d, err
 }
 
+// NewWithConfig creates a new Driver and initializes a connection to the
+// database using config. Unlike New, it allows settings a DSN cannot express,
+// such as a custom DialFunc for tunneling or a TLS configuration. The context
+// can be used to cancel the connection attempt.
+//
+// The conn will be closed when Close() is called on the returned Driver.
+func NewWithConfig(ctx context.Context, config *pgx.ConnConfig, opts ...Option) (m.Driver, error) {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newFromConn(ctx, conn, opts)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	d.closeConnOnClose = true
+	d.connConfig = config.Copy()
+	return d, nil
+}
+
 // NewFromConn creates a new Driver from an existing database connection. The
 // connection is pinged for availability before returning, and ctx can be used
 // to cancel the ping attempt.
 //
 // The conn will be closed after migrations complete (when Close() is called on
 // the driver).
-func NewFromConn(ctx context.Context, conn *pgx.Conn) (m.Driver, error) {
+func NewFromConn(ctx context.Context, conn *pgx.Conn, opts ...Option) (m.Driver, error) {
 	if err := conn.Ping(ctx); err != nil {
 		return nil, err
 	}
 
-	return newFromConn(ctx, conn)
+	return newFromConn(ctx, conn, opts)
 }
 
-func newFromConn(ctx context.Context, conn *pgx.Conn) (*Driver, error) {
-	d := &Driver{
-		conn: conn,
+// NewPool creates a new Driver backed by a connection pool. The pool is
+// configured using the DSN and the WithMaxConns and WithMinConns options, and
+// is closed when Close() is called on the returned Driver.
+//
+// Each migration runs on a single connection acquired from the pool, so a pool
+// with a single connection is enough to run migrations.
+func NewPool(ctx context.Context, dsn string, opts ...Option) (m.Driver, error) {
+	d, err := newDriver(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.maxConns > 0 {
+		config.MaxConns = d.maxConns
+	}
+	if d.minConns > 0 {
+		config.MinConns = d.minConns
+	}
+	config.AfterConnect = d.afterConnect
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	d.pool = pool
+	d.closeConnOnClose = true
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
 	}
+
 	if err := d.ensureVersionTableExists(ctx); err != nil {
+		pool.Close()
 		return nil, err
 	}
 
 	return d, nil
 }
 
-// Close closes the connection to the Driver server.
-func (driver *Driver) Close(ctx context.Context) error {
-	if driver.closeConnOnClose {
-		return driver.conn.Close(ctx)
+// NewFromPool creates a new Driver backed by an existing connection pool. The
+// pool is pinged for availability before returning, and ctx can be used to
+// cancel the ping attempt.
+//
+// The pool is owned by the caller: it is left open when Close() is called on
+// the driver. As the pool is already configured, the WithAfterConnect,
+// WithMaxConns and WithMinConns options have no effect.
+func NewFromPool(ctx context.Context, pool *pgxpool.Pool, opts ...Option) (m.Driver, error) {
+	d, err := newDriver(opts)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
+	d.pool = pool
 
-func (driver *Driver) ensureVersionTableExists(ctx context.Context) error {
-	_, err := driver.conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+postgresTableName+" (version varchar(255) not null primary key)")
-	return err
+	if err := pool.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := d.ensureVersionTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	return d, nil
 }
 
-// Migrate runs a migration.
-func (driver *Driver) Migrate(ctx context.Context, migration *m.PlannedMigration) (err error) {
-	var (
-		migrationStatements *parser.ParsedMigration
-		insertVersion       string
-	)
+func newDriver(opts []Option) (*Driver, error) {
+	d := &Driver{
+		versionColumnType: defaultVersionColumnType,
+		logger:            log.Default(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
 
-	if migration.Direction == m.Up {
-		migrationStatements = migration.Up
-		insertVersion = "INSERT INTO " + postgresTableName + " (version) VALUES ($1)"
-	} else if migration.Direction == m.Down {
-		migrationStatements = migration.Down
-		insertVersion = "DELETE FROM " + postgresTableName + " WHERE version=$1"
+	if _, ok := versionColumnTypes[d.versionColumnType]; !ok {
+		return nil, fmt.Errorf("unsupported version column type %q", d.versionColumnType)
 	}
 
-	if migrationStatements.UseTransaction {
-		tx, err := driver.conn.Begin(ctx)
-		if err != nil {
-			return err
+	if d.role != "" && !roleRegex.MatchString(d.role) {
+		return nil, fmt.Errorf("invalid role name %q", d.role)
+	}
+
+	if d.poolingMode == PoolingTransaction {
+		if d.prepareStatements {
+			return nil, errors.New("prepared statements cannot be used in transaction pooling mode")
+		}
+		if d.leaseTTL <= 0 {
+			d.leaseTTL = defaultLeaseTTL
 		}
+	}
 
-		defer func() {
-			if err != nil {
-				if errRb := tx.Rollback(context.Background()); errRb != nil {
-					err = fmt.Errorf("error rolling back: %s\n%s", errRb, err)
-				}
-				return
-			}
-			err = tx.Commit(ctx)
-		}()
+	d.tableName = postgresTableName
+	if d.namespace != "" {
+		if !namespaceRegex.MatchString(d.namespace) {
+			return nil, fmt.Errorf("invalid namespace %q", d.namespace)
+		}
+		d.tableName += "_" + d.namespace
+	}
 
-		for _, statement := range migrationStatements.Statements {
-			if _, err = tx.Exec(ctx, statement); err != nil {
-				return fmt.Errorf("error executing statement: %s\n%s", err, statement)
-			}
+	declared := map[string]bool{}
+	for _, column := range d.versionColumns {
+		switch {
+		case !versionColumnRegex.MatchString(column.Name):
+			return nil, fmt.Errorf("invalid version table column name %q", column.Name)
+		case reservedVersionColumns[column.Name]:
+			return nil, fmt.Errorf("version table column %s is managed by the driver", column.Name)
+		case declared[column.Name]:
+			return nil, fmt.Errorf("version table column %s is declared twice", column.Name)
+		case column.Type == "":
+			return nil, fmt.Errorf("version table column %s has no type", column.Name)
+		case !versionColumnTypeRegex.MatchString(column.Type):
+			return nil, fmt.Errorf("invalid type %q for version table column %s", column.Type, column.Name)
 		}
+		declared[column.Name] = true
+	}
+
+	return d, nil
+}
+
+func newFromConn(ctx context.Context, conn *pgx.Conn, opts []Option) (*Driver, error) {
+	d, err := newDriver(opts)
+	if err != nil {
+		return nil, err
+	}
+	d.conn = conn
 
-		if _, err = tx.Exec(ctx, insertVersion, migration.ID); err != nil {
-			return fmt.Errorf("error updating migration versions: %s", err)
+	if d.afterConnect != nil {
+		if err := d.afterConnect(ctx, conn); err != nil {
+			return nil, fmt.Errorf("error running after connect hook: %w", err)
 		}
-	} else {
-		for _, statement := range migrationStatements.Statements {
-			if _, err := driver.conn.Exec(ctx, statement); err != nil {
-				return fmt.Errorf("error executing statement: %s\n%s", err, statement)
+	}
+
+	if err := d.ensureVersionTableExists(ctx); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Close closes the connection to the Driver server. Like closing a pool, which
+// waits for acquired connections to be released, it waits for a call using
+// the single connection, such as a running migration, to finish.
+func (driver *Driver) Close(ctx context.Context) error {
+	if !driver.closeConnOnClose {
+		return nil
+	}
+	if driver.pool != nil {
+		driver.pool.Close()
+		return nil
+	}
+
+	driver.connMu.Lock()
+	defer driver.connMu.Unlock()
+
+	return driver.conn.Close(ctx)
+}
+
+// acquire returns the connection to work with. The returned function must be
+// called to release it: for pool-backed drivers, the connection is acquired
+// from the pool, otherwise the single connection is locked so that concurrent
+// calls are serialized.
+func (driver *Driver) acquire(ctx context.Context) (*pgx.Conn, func(), error) {
+	if driver.pool == nil {
+		driver.connMu.Lock()
+		if driver.conn.IsClosed() {
+			if err := driver.reconnectConn(ctx); err != nil {
+				driver.connMu.Unlock()
+				return nil, nil, err
 			}
 		}
-		if _, err = driver.conn.Exec(ctx, insertVersion, migration.ID); err != nil {
-			return fmt.Errorf("error updating migration versions: %s", err)
+		return driver.conn, driver.connMu.Unlock, nil
+	}
+
+	conn, err := driver.pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn.Conn(), conn.Release, nil
+}
+
+// acquireRead is like acquire, but returns the verification connection if one
+// is configured.
+func (driver *Driver) acquireRead(ctx context.Context) (*pgx.Conn, func(), error) {
+	if driver.verificationConn != nil {
+		driver.verificationConnMu.Lock()
+		if driver.verificationConn.IsClosed() {
+			driver.verificationConnMu.Unlock()
+			return nil, nil, &ConnectionClosedError{}
 		}
+		return driver.verificationConn, driver.verificationConnMu.Unlock, nil
 	}
-	return
+	return driver.acquire(ctx)
 }
 
-// Versions lists all the applied versions.
-func (driver *Driver) Versions(ctx context.Context) ([]string, error) {
-	var versions []string
+// reconnectConn replaces the closed conn with a new connection if
+// WithReconnect is used and the driver created conn, running the after
+// connect hook on it. connMu must be held.
+func (driver *Driver) reconnectConn(ctx context.Context) error {
+	if !driver.reconnect || driver.connConfig == nil {
+		return &ConnectionClosedError{}
+	}
 
-	rows, err := driver.conn.Query(ctx, "SELECT version FROM "+postgresTableName+" ORDER BY version DESC")
+	conn, err := pgx.ConnectConfig(ctx, driver.connConfig.Copy())
 	if err != nil {
-		return versions, err
+		return &ConnectionClosedError{Err: fmt.Errorf("error reconnecting: %w", err)}
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var version string
-		err = rows.Scan(&version)
+	if driver.afterConnect != nil {
+		if err := driver.afterConnect(ctx, conn); err != nil {
+			conn.Close(ctx)
+			return &ConnectionClosedError{Err: fmt.Errorf("error running after connect hook: %w", err)}
+		}
+	}
+
+	// The advisory lock held by Lock went away with the closed session.
+	if driver.advisory != nil {
+		acquired, err := driver.tryAdvisoryLock(ctx, conn)
 		if err != nil {
-			return versions, err
+			conn.Close(ctx)
+			return &ConnectionClosedError{Err: fmt.Errorf("error acquiring migration lock again: %w", err)}
+		}
+		if !acquired {
+			conn.Close(ctx)
+			return ErrLockLost
 		}
-		versions = append(versions, version)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+
+	driver.conn = conn
+	return nil
+}
+
+// shouldRetry reports whether an operation that failed with err should be
+// retried, which is when the connection was closed and WithReconnect is used,
+// so that acquiring a connection again reconnects. Only idempotent operations
+// may be retried.
+func (driver *Driver) shouldRetry(err error) bool {
+	var closed *ConnectionClosedError
+	return driver.reconnect && errors.As(err, &closed)
+}
+
+// Shutdown stops the driver from accepting new migrations, waits for an
+// in-flight migration to finish and then closes the driver. Unlike Close, which
+// only waits for the current call, this also refuses new migrations queued
+// behind it and lets the current migration complete rather than leaving its
+// statements half-applied.
+//
+// Waiting is bounded by ctx: if ctx is done first, in-flight migrations are
+// cancelled, rolling back those running in a transaction, and the driver is
+// closed once they returned. ctx.Err() is returned in that case.
+func (driver *Driver) Shutdown(ctx context.Context) error {
+	driver.mu.Lock()
+	driver.shuttingDown = true
+	driver.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		driver.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return driver.Close(ctx)
+	case <-ctx.Done():
+		// A previous call may have given up waiting already.
+		driver.mu.Lock()
+		if driver.abort == nil {
+			driver.abort = make(chan struct{})
+		}
+		if !driver.aborted {
+			close(driver.abort)
+			driver.aborted = true
+		}
+		driver.mu.Unlock()
+
+		<-done
+		if err := driver.Close(context.Background()); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+// track registers a migration as in-flight, unless the driver is shutting
+// down or lost the lease held by Lock. The returned context is cancelled if
+// Shutdown stops waiting for the migration or the lease is lost, and the
+// returned function must be called with the migration's error, if any, once
+// it finished. It replaces the error with ErrLockLost if the lease was lost.
+func (driver *Driver) track(ctx context.Context) (context.Context, func(err *error), error) {
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	if driver.shuttingDown {
+		return nil, nil, ErrShutdown
+	}
+	lost := driver.leaseLost
+	if lost.lost() {
+		return nil, nil, ErrLockLost
 	}
+	if driver.abort == nil {
+		driver.abort = make(chan struct{})
+	}
+	driver.inFlight.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	go func(abort chan struct{}) {
+		select {
+		case <-abort:
+			cancel()
+		case <-lost:
+			cancel()
+		case <-finished:
+		}
+	}(driver.abort)
+
+	return ctx, func(err *error) {
+		close(finished)
+		cancel()
+		if err != nil && *err != nil && lost.lost() {
+			*err = ErrLockLost
+		}
+		driver.inFlight.Done()
+	}, nil
+}
+
+func (driver *Driver) ensureVersionTableExists(ctx context.Context) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return driver.createVersionTable(ctx, conn)
+}
+
+// createVersionTable creates the version table in the current schema of q if
+// it doesn't exist, and upgrades it otherwise.
+func (driver *Driver) createVersionTable(ctx context.Context, q querier) error {
+	// Inspect the table before touching it, so a driver whose table is already
+	// up to date issues no DDL and works for users without CREATE or ALTER
+	// privileges.
+	var columns []string
+	err := q.QueryRow(ctx, "SELECT COALESCE(array_agg(column_name::text), '{}') FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", driver.queryArgs(driver.tableName)...).Scan(&columns)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, column := range columns {
+		existing[column] = true
+	}
+
+	if len(existing) == 0 {
+		if _, err := q.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.tableName+" (version "+driver.versionColumnType+" COLLATE \"C\" not null primary key)"); err != nil {
+			return err
+		}
+		existing["version"] = true
+	}
+
+	// Make sure a pre-existing table is ours before altering it.
+	if !existing["version"] {
+		return &IncompatibleVersionTableError{Table: driver.tableName}
+	}
+
+	// Columns added after the table was first introduced.
+	for _, column := range []struct{ name, definition string }{
+		{"metadata", "jsonb"},
+		{"applied_at", "timestamptz"},
+		{"sql", "text"},
+		{"checksum", "text"},
+	} {
+		if existing[column.name] {
+			continue
+		}
+		if _, err = q.Exec(ctx, "ALTER TABLE "+driver.tableName+" ADD COLUMN IF NOT EXISTS "+column.name+" "+column.definition); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range driver.versionColumns {
+		if existing[column.Name] {
+			continue
+		}
+		if _, err = q.Exec(ctx, "ALTER TABLE "+driver.tableName+" ADD COLUMN IF NOT EXISTS "+column.Name+" "+column.Type); err != nil {
+			return err
+		}
+	}
+
+	if driver.sequenceOrder && !existing["seq"] {
+		if err := driver.addSequenceColumn(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	if driver.history {
+		return driver.createHistoryTable(ctx, q)
+	}
+
+	return nil
+}
+
+// addSequenceColumn adds the seq column used by WithSequenceOrder to the
+// version table. The versions already recorded are numbered in the order
+// they were applied, as far as applied_at tells, rather than in the arbitrary
+// order in which adding a bigserial column numbers existing rows.
+func (driver *Driver) addSequenceColumn(ctx context.Context, q querier) error {
+	if _, err := q.Exec(ctx, "ALTER TABLE "+driver.tableName+" ADD COLUMN IF NOT EXISTS seq bigserial"); err != nil {
+		return err
+	}
+	_, err := q.Exec(ctx, "UPDATE "+driver.tableName+" AS t SET seq = o.seq FROM (SELECT version, row_number() OVER (ORDER BY applied_at NULLS FIRST, version COLLATE \"C\") AS seq FROM "+driver.tableName+") AS o WHERE t.version = o.version")
+	return err
+}
+
+// versionTableColumns returns the columns the version table is expected to
+// have, mapped to their information_schema data types.
+func (driver *Driver) versionTableColumns() map[string]string {
+	columns := map[string]string{
+		"version":    versionColumnTypes[driver.versionColumnType],
+		"metadata":   "jsonb",
+		"applied_at": "timestamp with time zone",
+		"sql":        "text",
+		"checksum":   "text",
+	}
+	if driver.sequenceOrder {
+		columns["seq"] = "bigint"
+	}
+	return columns
+}
+
+// CheckVersionTable inspects the version table and returns a descriptive error
+// if its shape doesn't match what the driver expects, for example because a
+// column was dropped, changed type or the primary key is missing. It can be
+// used to surface misconfiguration before running migrations.
+func (driver *Driver) CheckVersionTable(ctx context.Context) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rows, err := conn.Query(ctx, "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", driver.queryArgs(driver.tableName)...)
+	if err != nil {
+		return err
+	}
+
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = dataType
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		return fmt.Errorf("version table %s does not exist", driver.tableName)
+	}
+
+	var problems []string
+
+	for name, expectedType := range driver.versionTableColumns() {
+		dataType, ok := columns[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("column %s is missing", name))
+		case dataType != expectedType:
+			problems = append(problems, fmt.Sprintf("column %s has type %s, expected %s", name, dataType, expectedType))
+		}
+	}
+
+	var primaryKey []string
+	rows, err = conn.Query(ctx, `SELECT kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+		  ON tc.constraint_schema = kcu.constraint_schema AND tc.constraint_name = kcu.constraint_name
+		WHERE tc.table_schema = current_schema() AND tc.table_name = $1 AND tc.constraint_type = 'PRIMARY KEY'`, driver.queryArgs(driver.tableName)...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		primaryKey = append(primaryKey, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(primaryKey) != 1 || primaryKey[0] != "version" {
+		problems = append(problems, fmt.Sprintf("primary key is (%s), expected (version)", strings.Join(primaryKey, ", ")))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("version table %s does not have the expected shape: %s", driver.tableName, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Migrate runs a migration.
+func (driver *Driver) Migrate(ctx context.Context, migration *m.PlannedMigration) (err error) {
+	if migration.Direction != m.Up && migration.Direction != m.Down {
+		return &m.InvalidDirectionError{Direction: migration.Direction}
+	}
+
+	ctx, done, err := driver.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return driver.migrateOn(ctx, conn, migration)
+}
+
+// migrateOn runs a migration on conn.
+func (driver *Driver) migrateOn(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) (err error) {
+	defer func() {
+		err = closedErr(conn, err)
+	}()
+
+	defer driver.monitorBlocking(ctx, conn)()
+
+	// Registered before committing below, so it runs after the commit.
+	defer func() {
+		if err == nil {
+			driver.runPostHook(ctx, conn, migration)
+		}
+	}()
+
+	migrationStatements := statementsFor(migration)
+
+	if migrationStatements.UseTransaction {
+		if err := checkTransactional(migration); err != nil {
+			return err
+		}
+
+		tx, errBegin := conn.Begin(ctx)
+		if errBegin != nil {
+			return errBegin
+		}
+
+		defer func() {
+			if err != nil {
+				if errRb := tx.Rollback(context.Background()); errRb != nil {
+					err = fmt.Errorf("error rolling back: %s\n%s", errRb, err)
+					return
+				}
+				var statementErr *StatementError
+				if errors.As(err, &statementErr) {
+					driver.logger.Printf("Rolled back migration %s: statement %d failed after %d statements succeeded: %s", migration.ID, statementErr.Index, statementErr.Executed, statementErr.Err)
+				}
+				return
+			}
+			if errCommit := tx.Commit(ctx); errCommit != nil {
+				err = &CommitError{ID: migration.ID, Err: errCommit}
+			}
+		}()
+
+		if err = driver.checkApplied(ctx, tx, migration); err != nil {
+			return err
+		}
+
+		if err = driver.execMigrationStatements(ctx, tx, migration, true); err != nil {
+			return err
+		}
+
+		if err = driver.updateVersion(ctx, tx, migration); err != nil {
+			return err
+		}
+	} else {
+		if err := driver.checkApplied(ctx, conn, migration); err != nil {
+			return err
+		}
+		if err := driver.execMigrationStatements(ctx, conn, migration, false); err != nil {
+			return err
+		}
+		if err = driver.updateVersion(ctx, conn, migration); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// checkApplied returns an AlreadyAppliedError if migration is planned up but
+// its version is already recorded, or a NotAppliedError if it is planned down
+// but its version is not recorded. Recording a version again is allowed by
+// the VersionConflictIgnore and VersionConflictUpdate strategies.
+func (driver *Driver) checkApplied(ctx context.Context, q querier, migration *m.PlannedMigration) error {
+	if driver.untracked || (migration.Direction == m.Up && driver.versionConflict != VersionConflictError) {
+		return nil
+	}
+
+	query, err := driver.prepare(ctx, q, driver.tableName+"_version_exists", "SELECT EXISTS (SELECT 1 FROM "+driver.tableName+" WHERE version=$1)")
+	if err != nil {
+		return err
+	}
+
+	var applied bool
+	if err := q.QueryRow(ctx, query, driver.queryArgs(migration.ID)...).Scan(&applied); err != nil {
+		return fmt.Errorf("error checking whether migration %s is applied: %s", migration.ID, err)
+	}
+
+	switch {
+	case migration.Direction == m.Up && applied:
+		return &AlreadyAppliedError{ID: migration.ID}
+	case migration.Direction == m.Down && !applied:
+		return &NotAppliedError{ID: migration.ID}
+	}
+	return nil
+}
+
+// updateVersion records or removes the migration's version, depending on its
+// direction.
+func (driver *Driver) updateVersion(ctx context.Context, q querier, migration *m.PlannedMigration) error {
+	if driver.untracked {
+		return nil
+	}
+
+	var err error
+
+	// Without a clock, the server's time is recorded.
+	var appliedAt *time.Time
+	if driver.clock != nil {
+		now := driver.clock()
+		appliedAt = &now
+	}
+
+	if migration.Direction == m.Up {
+		// Metadata is passed as text, which unlike []byte is also encoded
+		// as JSON by the simple protocol used in transaction pooling mode.
+		var metadata *string
+		if metadataMap := withDeployID(ctx, migration.Metadata); len(metadataMap) > 0 {
+			encoded, err := json.Marshal(metadataMap)
+			if err != nil {
+				return fmt.Errorf("error encoding migration metadata: %s", err)
+			}
+			text := string(encoded)
+			metadata = &text
+		}
+		var sql *string
+		if driver.storeSQL {
+			joined := joinStatements(statementsFor(migration).Statements)
+			sql = &joined
+		}
+		columns := "version, metadata, applied_at, sql, checksum"
+		// now() is the start time of the transaction, which several
+		// migrations may share.
+		values := "$1, $2, COALESCE($3, clock_timestamp()), $4, $5"
+		args := []interface{}{migration.ID, metadata, appliedAt, sql, migration.Checksum()}
+		onConflict := driver.versionConflict.onConflict()
+		if driver.sequenceOrder && driver.versionConflict == VersionConflictUpdate {
+			// Re-recording a version moves it to the end of the order.
+			onConflict += ", seq = DEFAULT"
+		}
+		if len(driver.versionColumns) > 0 {
+			row, err := driver.buildVersionRow(migration)
+			if err != nil {
+				return err
+			}
+			for _, column := range driver.versionColumns {
+				args = append(args, row[column.Name])
+				columns += ", " + column.Name
+				values += fmt.Sprintf(", $%d", len(args))
+				if driver.versionConflict == VersionConflictUpdate {
+					onConflict += ", " + column.Name + " = EXCLUDED." + column.Name
+				}
+			}
+		}
+		var insert string
+		insert, err = driver.prepare(ctx, q, driver.tableName+"_insert_version", "INSERT INTO "+driver.tableName+" ("+columns+") VALUES ("+values+")"+onConflict)
+		if err == nil {
+			_, err = q.Exec(ctx, insert, driver.queryArgs(args...)...)
+		}
+	} else {
+		var remove string
+		remove, err = driver.prepare(ctx, q, driver.tableName+"_delete_version", "DELETE FROM "+driver.tableName+" WHERE version=$1")
+		if err == nil {
+			_, err = q.Exec(ctx, remove, driver.queryArgs(migration.ID)...)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("error updating migration versions: %s", err)
+	}
+
+	if driver.history {
+		if err := driver.recordHistory(ctx, q, migration, appliedAt); err != nil {
+			return err
+		}
+	}
+
+	if driver.notifyChannel != "" {
+		payload := migration.ID + ":" + migration.Direction.String()
+		if _, err := q.Exec(ctx, "SELECT pg_notify($1, $2)", driver.queryArgs(driver.notifyChannel, payload)...); err != nil {
+			return fmt.Errorf("error notifying channel %s: %s", driver.notifyChannel, err)
+		}
+	}
+
+	return nil
+}
+
+// buildVersionRow returns the values of the extra version table columns for
+// migration, as returned by the builder set with WithVersionRowBuilder.
+func (driver *Driver) buildVersionRow(migration *m.PlannedMigration) (map[string]interface{}, error) {
+	if driver.versionRowBuilder == nil {
+		return nil, nil
+	}
+
+	row := driver.versionRowBuilder(migration)
+
+	declared := map[string]bool{}
+	for _, column := range driver.versionColumns {
+		declared[column.Name] = true
+	}
+	for name := range row {
+		if !declared[name] {
+			return nil, fmt.Errorf("version row builder returned undeclared column %q for migration %s", name, migration.ID)
+		}
+	}
+
+	return row, nil
+}
+
+// withDeployID returns metadata with the deploy ID of ctx added, if any and if
+// metadata doesn't already set it.
+func withDeployID(ctx context.Context, metadata map[string]string) map[string]string {
+	deployID, ok := m.DeployIDFromContext(ctx)
+	if !ok {
+		return metadata
+	}
+	if _, set := metadata[m.DeployIDMetadataKey]; set {
+		return metadata
+	}
+
+	withID := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		withID[key] = value
+	}
+	withID[m.DeployIDMetadataKey] = deployID
+	return withID
+}
+
+// prepare returns the statement to run for sql. With WithPreparedStatements,
+// sql is prepared as name on the connection behind q, which pgx only does
+// once per connection, and name is returned. Otherwise sql is returned as is.
+func (driver *Driver) prepare(ctx context.Context, q querier, name, sql string) (string, error) {
+	if !driver.prepareStatements {
+		return sql, nil
+	}
+
+	conn := connOf(q)
+	if conn == nil {
+		return sql, nil
+	}
+
+	if _, err := conn.Prepare(ctx, name, sql); err != nil {
+		return "", fmt.Errorf("error preparing statement %s: %s", name, err)
+	}
+
+	return name, nil
+}
+
+// Exec runs statements without recording a version. It implements
+// migration.Execer.
+func (driver *Driver) Exec(ctx context.Context, statements *parser.ParsedMigration) (err error) {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer func() {
+		err = closedErr(conn, err)
+	}()
+
+	if !statements.UseTransaction {
+		return execStatements(ctx, conn, statements.Statements)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := execStatements(ctx, tx, statements.Statements); err != nil {
+		if errRb := tx.Rollback(context.Background()); errRb != nil {
+			return fmt.Errorf("error rolling back: %s\n%s", errRb, err)
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// statementsFor returns the statements to run for the direction of migration.
+func statementsFor(migration *m.PlannedMigration) *parser.ParsedMigration {
+	if migration.Direction == m.Down {
+		return migration.Down
+	}
+	return migration.Up
+}
+
+// ValidateStatements checks statements for syntax errors without running
+// them. It implements migration.Validator.
+//
+// The statements are compiled as the body of a PL/pgSQL function, which
+// parses each of them but does not analyze them, so statements may refer to
+// tables created by earlier statements or migrations. The function is created
+// in a transaction that is always rolled back, leaving no trace.
+//
+// Statements that PL/pgSQL reads differently than plain SQL are not
+// validated: transaction control such as BEGIN and COMMIT, DO blocks,
+// CREATE FUNCTION and CREATE PROCEDURE, EXECUTE, cursor commands, SELECT ...
+// INTO and COPY ... FROM stdin.
+func (driver *Driver) ValidateStatements(ctx context.Context, statements *parser.ParsedMigration) error {
+	body := validationBody(statements)
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, "SET LOCAL check_function_bodies = on"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "CREATE FUNCTION pg_temp.migration_validate() RETURNS void LANGUAGE plpgsql AS "+quoteBody(body))
+	return err
+}
+
+// validationBody returns the body of the PL/pgSQL function compiled by
+// ValidateStatements, leaving out the statements it cannot validate.
+func validationBody(statements *parser.ParsedMigration) string {
+	var body strings.Builder
+	body.WriteString("BEGIN\n")
+	for _, block := range statements.Statements {
+		for _, statement := range parser.SplitStatements(block) {
+			// PL/pgSQL cannot read data from the client.
+			if _, _, ok := parser.SplitCopy(statement); ok {
+				continue
+			}
+			if unvalidatableRegex.MatchString(statement) {
+				continue
+			}
+			if statement = strings.TrimSpace(trimStatement(statement)); statement == "" {
+				continue
+			}
+			body.WriteString(statement)
+			body.WriteString(";\n")
+		}
+	}
+	body.WriteString("END")
+	return body.String()
+}
+
+// checkTransactional returns a NonTransactionalStatementError if the
+// statements of migration include one that cannot run in a transaction.
+func checkTransactional(migration *m.PlannedMigration) error {
+	for _, block := range statementsFor(migration).Statements {
+		for _, statement := range parser.SplitStatements(block) {
+			if command, _, ok := parser.SplitCopy(statement); ok {
+				statement = command
+			}
+			if nonTransactionalRegex.MatchString(statement) {
+				return &NonTransactionalStatementError{ID: migration.ID, Statement: strings.TrimSpace(statement)}
+			}
+		}
+	}
+	return nil
+}
+
+// EvaluateGuard runs the statements of guard in a read-only transaction that
+// is rolled back, and reports whether the last statement returned a row whose
+// first column is neither false nor NULL. It implements
+// migration.GuardEvaluator.
+func (driver *Driver) EvaluateGuard(ctx context.Context, guard *parser.ParsedMigration) (bool, error) {
+	if len(guard.Statements) == 0 {
+		return false, errors.New("guard has no statements")
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return false, closedErr(conn, err)
+	}
+	defer tx.Rollback(context.Background())
+
+	last := len(guard.Statements) - 1
+	if err := execStatements(ctx, tx, guard.Statements[:last]); err != nil {
+		return false, err
+	}
+
+	rows, err := tx.Query(ctx, guard.Statements[last], driver.queryArgs()...)
+	if err != nil {
+		return false, fmt.Errorf("error executing guard: %s\n%s", err, guard.Statements[last])
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf("error executing guard: %s\n%s", err, guard.Statements[last])
+		}
+		return false, nil
+	}
+
+	values, err := rows.Values()
+	if err != nil {
+		return false, err
+	}
+	if len(values) == 0 {
+		return false, errors.New("guard returned no columns")
+	}
+
+	if pass, ok := values[0].(bool); ok {
+		return pass, nil
+	}
+	return values[0] != nil, nil
+}
+
+// trimStatement removes the terminator of statement, along with trailing
+// whitespace and comment lines, which would otherwise swallow a terminator
+// appended to it.
+func trimStatement(statement string) string {
+	for {
+		statement = strings.TrimRightFunc(statement, func(r rune) bool {
+			return r == ';' || unicode.IsSpace(r)
+		})
+
+		i := strings.LastIndex(statement, "\n")
+		if !strings.HasPrefix(strings.TrimSpace(statement[i+1:]), "--") {
+			return statement
+		}
+		statement = statement[:i+1]
+	}
+}
+
+// quoteBody dollar-quotes body using a tag that does not occur in it.
+func quoteBody(body string) string {
+	tag := "$migration_validate$"
+	for i := 0; strings.Contains(body, tag); i++ {
+		tag = fmt.Sprintf("$migration_validate_%d$", i)
+	}
+	return tag + body + tag
+}
+
+// SupportsTransactionalDDL reports that PostgreSQL can roll back schema
+// changes as part of a transaction.
+func (driver *Driver) SupportsTransactionalDDL() bool {
+	return true
+}
+
+// ServerVersion returns the version of the PostgreSQL server, such as
+// "15.2 (Debian 15.2-1.pgdg110+1)".
+func (driver *Driver) ServerVersion(ctx context.Context) (string, error) {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var version string
+	if err := conn.QueryRow(ctx, "SHOW server_version", driver.queryArgs()...).Scan(&version); err != nil {
+		return "", closedErr(conn, err)
+	}
+	return version, nil
+}
+
+// MigrateBatch applies all migrations and records their versions in a single
+// transaction. Migrations that opt out of transactions, for example to run
+// CREATE INDEX CONCURRENTLY, cannot be part of a batch.
+func (driver *Driver) MigrateBatch(ctx context.Context, migrations []*m.PlannedMigration) (err error) {
+	ctx, done, err := driver.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+
+	if err := checkBatchable(migrations); err != nil {
+		return err
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer driver.monitorBlocking(ctx, conn)()
+
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, migration := range migrations {
+			if err := driver.checkApplied(ctx, tx, migration); err != nil {
+				return err
+			}
+			if err := driver.execMigrationStatements(ctx, tx, migration, true); err != nil {
+				return fmt.Errorf("error applying migration %s: %w", migration.ID, err)
+			}
+			if err := driver.updateVersion(ctx, tx, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		driver.runPostHook(ctx, conn, migration)
+	}
+	return nil
+}
+
+// MigrateWithSavepoints applies migrations in a single transaction, running
+// each in its own savepoint, so that a failing migration is rolled back alone.
+// It implements migration.SavepointBatcher. Like MigrateBatch, it cannot
+// apply migrations that opt out of transactions.
+func (driver *Driver) MigrateWithSavepoints(ctx context.Context, migrations []*m.PlannedMigration, onError func(migration *m.PlannedMigration, err error) bool) (err error) {
+	ctx, done, err := driver.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+
+	if err := checkBatchable(migrations); err != nil {
+		return err
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer func() {
+		err = closedErr(conn, err)
+	}()
+
+	defer driver.monitorBlocking(ctx, conn)()
+
+	var applied []*m.PlannedMigration
+
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, migration := range migrations {
+			// Beginning a transaction within tx creates a savepoint.
+			err := pgx.BeginFunc(ctx, tx, func(savepoint pgx.Tx) error {
+				if err := driver.checkApplied(ctx, savepoint, migration); err != nil {
+					return err
+				}
+				if err := driver.execMigrationStatements(ctx, savepoint, migration, true); err != nil {
+					return err
+				}
+				return driver.updateVersion(ctx, savepoint, migration)
+			})
+			if err != nil {
+				// A failure that aborted the transaction itself, such as a
+				// lost connection, cannot be recovered from.
+				if conn.IsClosed() || ctx.Err() != nil {
+					return err
+				}
+				if !onError(migration, err) {
+					break
+				}
+				continue
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range applied {
+		driver.runPostHook(ctx, conn, migration)
+	}
+	return nil
+}
+
+// checkBatchable returns an error if any of migrations cannot be applied
+// within a transaction shared with the others.
+func checkBatchable(migrations []*m.PlannedMigration) error {
+	for _, migration := range migrations {
+		if migration.Direction != m.Up && migration.Direction != m.Down {
+			return &m.InvalidDirectionError{Direction: migration.Direction}
+		}
+		if !statementsFor(migration).UseTransaction {
+			return fmt.Errorf("migration %s does not use a transaction and cannot be applied in a batch", migration.ID)
+		}
+		if err := checkTransactional(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostHook runs the post-hook statements of a migration that has been
+// applied, outside of any transaction. Failures are logged as warnings, as
+// the migration itself has been applied and recorded.
+func (driver *Driver) runPostHook(ctx context.Context, conn *pgx.Conn, migration *m.PlannedMigration) {
+	if migration.Direction != m.Up {
+		return
+	}
+
+	for _, statement := range migration.PostHook {
+		if _, err := conn.Exec(ctx, statement); err != nil {
+			driver.logger.Printf("Warning: post-hook statement of migration %s failed: %s\n%s", migration.ID, err, statement)
+		}
+	}
+}
+
+// joinStatements joins statements into a single text, separating them with a
+// newline unless they are already separated by whitespace.
+func joinStatements(statements []string) string {
+	var b strings.Builder
+	for i, statement := range statements {
+		if i > 0 && !endsWithSpace(statements[i-1]) && !startsWithSpace(statement) {
+			b.WriteString("\n")
+		}
+		b.WriteString(statement)
+	}
+	return b.String()
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && unicode.IsSpace(rune(s[0]))
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && unicode.IsSpace(rune(s[len(s)-1]))
+}
+
+// execMigrationStatements runs the statements of a migration as the role set
+// with WithDefaultRole, if any, resetting the role afterwards so that the
+// version table is updated as the connecting user, and with the lock timeout
+// set with WithLockTimeout, if any. inTx must be set when q is a transaction,
+// so that these settings only apply to the transaction. The hooks set with
+// WithBeforeDDL and WithAfterDDL run around migrations containing DDL, outside
+// of these settings.
+func (driver *Driver) execMigrationStatements(ctx context.Context, q querier, migration *m.PlannedMigration, inTx bool) (err error) {
+	if (driver.beforeDDL != nil || driver.afterDDL != nil) && containsDDL(statementsFor(migration)) {
+		conn := connOf(q)
+
+		if driver.beforeDDL != nil {
+			if err := driver.beforeDDL(ctx, conn, migration); err != nil {
+				return fmt.Errorf("error running the before-DDL hook of migration %s: %w", migration.ID, err)
+			}
+		}
+
+		// Registered first, so it runs after the settings are reset.
+		defer func() {
+			if err != nil || driver.afterDDL == nil {
+				return
+			}
+			if errHook := driver.afterDDL(ctx, conn, migration); errHook != nil {
+				err = fmt.Errorf("error running the after-DDL hook of migration %s: %w", migration.ID, errHook)
+			}
+		}()
+	}
+
+	if driver.lockTimeout > 0 {
+		if err := driver.setLockTimeout(ctx, q, inTx); err != nil {
+			return err
+		}
+
+		defer func() {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "55P03" {
+				err = &LockTimeoutError{ID: migration.ID, Timeout: driver.lockTimeout, Err: err}
+			}
+
+			// The transaction ends the setting of SET LOCAL.
+			if inTx {
+				return
+			}
+			if _, errReset := q.Exec(context.Background(), "RESET lock_timeout"); errReset != nil && err == nil {
+				err = fmt.Errorf("error resetting lock_timeout: %s", errReset)
+			}
+		}()
+	}
+
+	if driver.statementTimeout > 0 {
+		restore, errSet := driver.setStatementTimeout(ctx, q, inTx)
+		if errSet != nil {
+			return errSet
+		}
+
+		defer func() {
+			if errRestore := restore(); errRestore != nil && err == nil {
+				err = errRestore
+			}
+		}()
+	}
+
+	if driver.role == "" {
+		return driver.execTimedStatements(ctx, q, migration)
+	}
+
+	set := "SET ROLE "
+	if inTx {
+		set = "SET LOCAL ROLE "
+	}
+	if _, err := q.Exec(ctx, set+pgx.Identifier{driver.role}.Sanitize()); err != nil {
+		return fmt.Errorf("error setting role %s: %s", driver.role, err)
+	}
+
+	defer func() {
+		// A failed transaction is rolled back, which resets the role.
+		if err != nil && inTx {
+			return
+		}
+		if _, errReset := q.Exec(context.Background(), "RESET ROLE"); errReset != nil && err == nil {
+			err = fmt.Errorf("error resetting role: %s", errReset)
+		}
+	}()
+
+	return driver.execTimedStatements(ctx, q, migration)
+}
+
+// setLockTimeout sets lock_timeout to the timeout set with WithLockTimeout, for
+// the transaction if inTx is set and for the session otherwise.
+func (driver *Driver) setLockTimeout(ctx context.Context, q querier, inTx bool) error {
+	set := "SET lock_timeout = "
+	if inTx {
+		set = "SET LOCAL lock_timeout = "
+	}
+
+	// A lock_timeout of 0 disables the timeout.
+	milliseconds := driver.lockTimeout.Milliseconds()
+	if milliseconds < 1 {
+		milliseconds = 1
+	}
+
+	if _, err := q.Exec(ctx, set+strconv.FormatInt(milliseconds, 10)); err != nil {
+		return fmt.Errorf("error setting lock_timeout: %s", err)
+	}
+	return nil
+}
+
+// setStatementTimeout sets statement_timeout to the timeout set with
+// WithPerStatementTimeout, so that the server cancels statements running for
+// longer while the connection stays usable. In a transaction, SET LOCAL scopes
+// the setting to it. Otherwise, the returned function restores the setting the
+// session had before.
+func (driver *Driver) setStatementTimeout(ctx context.Context, q querier, inTx bool) (func() error, error) {
+	// A statement_timeout of 0 disables the timeout.
+	milliseconds := driver.statementTimeout.Milliseconds()
+	if milliseconds < 1 {
+		milliseconds = 1
+	}
+	timeout := strconv.FormatInt(milliseconds, 10)
+
+	if inTx {
+		if _, err := q.Exec(ctx, "SET LOCAL statement_timeout = "+timeout); err != nil {
+			return nil, fmt.Errorf("error setting statement_timeout: %s", err)
+		}
+		return func() error { return nil }, nil
+	}
+
+	var previous string
+	if err := q.QueryRow(ctx, "SHOW statement_timeout", driver.queryArgs()...).Scan(&previous); err != nil {
+		return nil, fmt.Errorf("error reading statement_timeout: %s", err)
+	}
+
+	if _, err := q.Exec(ctx, "SET statement_timeout = "+timeout); err != nil {
+		return nil, fmt.Errorf("error setting statement_timeout: %s", err)
+	}
+
+	return func() error {
+		if _, err := q.Exec(context.Background(), "SELECT set_config('statement_timeout', $1, false)", driver.queryArgs(previous)...); err != nil {
+			return fmt.Errorf("error restoring statement_timeout: %s", err)
+		}
+		return nil
+	}, nil
+}
+
+// execTimedStatements runs the statements of migration. A statement cancelled
+// by the statement_timeout set with WithPerStatementTimeout fails with a
+// StatementTimeoutError. The data of COPY ... FROM stdin statements, as found
+// in dumps, is sent with the COPY protocol.
+func (driver *Driver) execTimedStatements(ctx context.Context, q querier, migration *m.PlannedMigration) error {
+	// Blocks are split so that a failure is attributed to the statement
+	// that caused it, which also runs each statement under its own timeout.
+	i := 0
+	for _, block := range statementsFor(migration).Statements {
+		for _, statement := range parser.SplitStatements(block) {
+			var err error
+			if command, data, ok := parser.SplitCopy(statement); ok {
+				statement = driver.rewriteStatements(command)
+				err = copyFrom(ctx, q, statement, data)
+			} else {
+				statement = driver.rewriteStatements(statement)
+				_, err = q.Exec(ctx, statement)
+			}
+
+			if err != nil {
+				// query_canceled is also reported when ctx is cancelled.
+				var pgErr *pgconn.PgError
+				if driver.statementTimeout > 0 && errors.As(err, &pgErr) && pgErr.Code == "57014" && ctx.Err() == nil {
+					return &StatementTimeoutError{ID: migration.ID, Index: i, Timeout: driver.statementTimeout, Err: err}
+				}
+				return &StatementError{ID: migration.ID, Index: i, Executed: i, Statement: statement, Err: err}
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// rewriteStatements applies the rewrites enabled by WithIdempotentGuards and
+// WithSchemaPrefix to statements.
+func (driver *Driver) rewriteStatements(statements string) string {
+	if driver.idempotentGuards {
+		statements = idempotentStatements(statements)
+	}
+	if driver.schemaPrefix != "" {
+		statements = schemaPrefixedStatements(statements, driver.schemaPrefix)
+	}
+	return statements
+}
+
+// copyFrom runs command, a COPY ... FROM stdin statement, sending data with
+// the COPY protocol. The data is in the format the command expects, tab
+// separated text by default, as written by pg_dump.
+func copyFrom(ctx context.Context, q querier, command, data string) error {
+	conn := connOf(q)
+	if conn == nil {
+		return fmt.Errorf("cannot run COPY on %T", q)
+	}
+
+	_, err := conn.PgConn().CopyFrom(ctx, strings.NewReader(data), command)
+	return err
+}
+
+// execStatements runs statements in order. Exec discards any rows returned,
+// so statements returning result sets, such as a SELECT calling a function,
+// leave the connection ready for the next statement.
+func execStatements(ctx context.Context, q querier, statements []string) error {
+	for _, statement := range statements {
+		if _, err := q.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("error executing statement: %s\n%s", err, statement)
+		}
+	}
+	return nil
+}
+
+// Uninstall drops the version table along with the history, history archive,
+// repeatable migrations, lock and asynchronous migrations tables created by
+// WithHistory, ArchiveHistory, repeatable migrations, WithLeaseLock and
+// MigrateAsync, removing every trace of the driver from the database, for
+// example when a project stops using it. The schema created by migrations is
+// left untouched. Uninstall is never called automatically, and the driver
+// should not be used afterwards.
+func (driver *Driver) Uninstall(ctx context.Context) error {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = conn.Exec(ctx, "DROP TABLE IF EXISTS "+driver.tableName+", "+driver.historyTable()+", "+driver.historyArchiveTable()+", "+driver.repeatableTable()+", "+driver.leaseTable()+", "+driver.asyncTable())
+	if err != nil {
+		return fmt.Errorf("error dropping version tables: %s", err)
+	}
+
+	return nil
+}
+
+// RecordVersions records ids as applied without running any migrations, for
+// example to baseline a database whose schema was created by other means. All
+// ids are inserted with a single statement, and ids that are already recorded
+// are left untouched.
+func (driver *Driver) RecordVersions(ctx context.Context, ids []string) error {
+	if driver.untracked {
+		return nil
+	}
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var appliedAt *time.Time
+	if driver.clock != nil {
+		now := driver.clock()
+		appliedAt = &now
+	}
+
+	_, err = conn.Exec(ctx, "INSERT INTO "+driver.tableName+" (version, applied_at) SELECT unnest($1::text[]), COALESCE($2, now()) ON CONFLICT (version) DO NOTHING", driver.queryArgs(ids, appliedAt)...)
+	if err != nil {
+		return fmt.Errorf("error recording migration versions: %s", err)
+	}
+	return nil
+}
+
+// Versions lists all the applied versions in the order they were applied,
+// oldest first, so rollbacks undo them in reverse. The order is taken from the
+// applied_at column; versions recorded without it, or at the same instant, for
+// example by a frozen clock set with WithClock, are ordered canonically, as
+// defined by migration.CompareIDs. Sorting happens in Go rather than with an
+// ORDER BY, so it doesn't depend on the collation of the database. With
+// WithSequenceOrder, the order is strictly that of the seq column instead.
+func (driver *Driver) Versions(ctx context.Context) ([]string, error) {
+	type appliedVersion struct {
+		version   string
+		appliedAt *time.Time
+	}
+
+	var versions []appliedVersion
+
+	err := driver.appliedVersionsFunc(ctx, func(version string, appliedAt *time.Time) error {
+		versions = append(versions, appliedVersion{version: version, appliedAt: appliedAt})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// With WithSequenceOrder, the versions are streamed in seq order.
+	if !driver.sequenceOrder {
+		sort.Slice(versions, func(i, j int) bool {
+			a, b := versions[i].appliedAt, versions[j].appliedAt
+			switch {
+			case a == nil && b != nil:
+				return true
+			case a != nil && b == nil:
+				return false
+			case a != nil && b != nil && !a.Equal(*b):
+				return a.Before(*b)
+			}
+			return m.CompareIDs(versions[i].version, versions[j].version) < 0
+		})
+	}
+
+	ids := make([]string, 0, len(versions))
+	for _, version := range versions {
+		ids = append(ids, version.version)
+	}
+
+	return ids, nil
+}
+
+// VersionsFunc streams the applied versions to fn in no particular order,
+// without materializing them all at once. If fn returns an error, iteration
+// stops and that error is returned. fn must not call methods of the driver,
+// as the connection is held while iterating.
+func (driver *Driver) VersionsFunc(ctx context.Context, fn func(version string) error) error {
+	return driver.appliedVersionsFunc(ctx, func(version string, _ *time.Time) error {
+		return fn(version)
+	})
+}
+
+// appliedVersionsFunc streams the applied versions to fn along with the time
+// they were applied, which is nil if it wasn't recorded.
+func (driver *Driver) appliedVersionsFunc(ctx context.Context, fn func(version string, appliedAt *time.Time) error) error {
+	if driver.untracked {
+		return nil
+	}
+
+	// Once fn has seen a version, retrying would repeat versions.
+	streamed := false
+
+	err := driver.versionsFunc(ctx, func(version string, appliedAt *time.Time) error {
+		streamed = true
+		return fn(version, appliedAt)
+	})
+	if !streamed && driver.shouldRetry(err) {
+		return driver.versionsFunc(ctx, fn)
+	}
+	return err
+}
+
+func (driver *Driver) versionsFunc(ctx context.Context, fn func(version string, appliedAt *time.Time) error) error {
+	conn, release, err := driver.acquireRead(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	sql := "SELECT version, applied_at FROM " + driver.tableName
+	if driver.sequenceOrder {
+		sql += " ORDER BY seq"
+	}
+
+	query, err := driver.prepare(ctx, conn, driver.tableName+"_versions", sql)
+	if err != nil {
+		return closedErr(conn, err)
+	}
+
+	rows, err := conn.Query(ctx, query, driver.queryArgs()...)
+	if err != nil {
+		return closedErr(conn, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version string
+		var appliedAt *time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return err
+		}
+		if err := fn(version, appliedAt); err != nil {
+			return err
+		}
+	}
+
+	return closedErr(conn, rows.Err())
+}
+
+// AppliedMigrations lists all the applied migrations in canonical order along
+// with the metadata recorded when they were applied.
+func (driver *Driver) AppliedMigrations(ctx context.Context) ([]m.AppliedMigration, error) {
+	if driver.untracked {
+		return nil, nil
+	}
+
+	applied, err := driver.appliedMigrations(ctx)
+	if driver.shouldRetry(err) {
+		return driver.appliedMigrations(ctx)
+	}
+	return applied, err
+}
+
+func (driver *Driver) appliedMigrations(ctx context.Context) ([]m.AppliedMigration, error) {
+	var applied []m.AppliedMigration
+
+	conn, release, err := driver.acquireRead(ctx)
+	if err != nil {
+		return applied, err
+	}
+	defer release()
+
+	rows, err := conn.Query(ctx, "SELECT version, metadata, applied_at, checksum FROM "+driver.tableName, driver.queryArgs()...)
+	if err != nil {
+		return applied, closedErr(conn, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			migration m.AppliedMigration
+			metadata  []byte
+			appliedAt *time.Time
+			checksum  *string
+		)
+		if err := rows.Scan(&migration.ID, &metadata, &appliedAt, &checksum); err != nil {
+			return applied, err
+		}
+		if checksum != nil {
+			migration.Checksum = *checksum
+		}
+		if appliedAt != nil {
+			migration.AppliedAt = *appliedAt
+		}
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &migration.Metadata); err != nil {
+				return applied, fmt.Errorf("error decoding metadata for migration %s: %s", migration.ID, err)
+			}
+		}
+		applied = append(applied, migration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, closedErr(conn, err)
+	}
+
+	sort.Slice(applied, func(i, j int) bool {
+		return m.CompareIDs(applied[i].ID, applied[j].ID) < 0
+	})
 
-	return versions, nil
+	return applied, nil
 }
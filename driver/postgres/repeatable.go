@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// repeatableTable is the name of the table recording the checksums of
+// repeatable migrations.
+func (driver *Driver) repeatableTable() string {
+	return driver.tableName + "_repeatable"
+}
+
+func (driver *Driver) createRepeatableTable(ctx context.Context, q querier) error {
+	_, err := q.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+driver.repeatableTable()+" (name text not null primary key, checksum text not null, applied_at timestamptz not null)")
+	if err != nil {
+		return fmt.Errorf("error creating repeatable migrations table: %s", err)
+	}
+	return nil
+}
+
+// RepeatableChecksums returns the checksum recorded when each repeatable
+// migration was last applied, by ID.
+func (driver *Driver) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := driver.createRepeatableTable(ctx, conn); err != nil {
+		return nil, closedErr(conn, err)
+	}
+
+	rows, err := conn.Query(ctx, "SELECT name, checksum FROM "+driver.repeatableTable(), driver.queryArgs()...)
+	if err != nil {
+		return nil, closedErr(conn, err)
+	}
+	defer rows.Close()
+
+	checksums := map[string]string{}
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// MigrateRepeatable runs a repeatable migration and records its checksum.
+// Unless the migration opts out of transactions, both happen in the same
+// transaction.
+func (driver *Driver) MigrateRepeatable(ctx context.Context, migration *m.PlannedMigration) (err error) {
+	ctx, done, err := driver.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer func() {
+		err = closedErr(conn, err)
+	}()
+
+	if err := driver.createRepeatableTable(ctx, conn); err != nil {
+		return err
+	}
+
+	if !migration.Up.UseTransaction {
+		if err := driver.execMigrationStatements(ctx, conn, migration, false); err != nil {
+			return err
+		}
+		return driver.recordRepeatable(ctx, conn, migration)
+	}
+
+	if err := checkTransactional(migration); err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if err := driver.execMigrationStatements(ctx, tx, migration, true); err != nil {
+			return err
+		}
+		return driver.recordRepeatable(ctx, tx, migration)
+	})
+}
+
+// recordRepeatable records the checksum of migration, replacing the previous
+// one. Without a clock, the server's time is recorded.
+func (driver *Driver) recordRepeatable(ctx context.Context, q querier, migration *m.PlannedMigration) error {
+	var appliedAt *time.Time
+	if driver.clock != nil {
+		now := driver.clock()
+		appliedAt = &now
+	}
+
+	_, err := q.Exec(ctx, "INSERT INTO "+driver.repeatableTable()+" (name, checksum, applied_at) VALUES ($1, $2, COALESCE($3, now())) "+
+		"ON CONFLICT (name) DO UPDATE SET checksum = EXCLUDED.checksum, applied_at = EXCLUDED.applied_at",
+		driver.queryArgs(migration.ID, migration.Checksum(), appliedAt)...)
+	if err != nil {
+		return fmt.Errorf("error recording repeatable migration %s: %s", migration.ID, err)
+	}
+	return nil
+}
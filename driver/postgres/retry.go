@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	m "github.com/muxinc/migration"
+)
+
+const (
+	initialConnectBackoff = 100 * time.Millisecond
+	maxConnectBackoff     = 5 * time.Second
+)
+
+// NewWithRetry creates a new Driver like New, retrying to connect with
+// exponential backoff while the database is unavailable, for example because
+// it is still starting up alongside the application in docker compose or
+// Kubernetes. It gives up once ctx is done or maxWait has elapsed, returning
+// the last error.
+//
+// Only errors indicating that the server can't be reached yet are retried:
+// refused or reset connections, host names that don't resolve yet and
+// servers that are still starting up. Other errors, such as authentication
+// failures, are returned immediately.
+func NewWithRetry(ctx context.Context, dsn string, maxWait time.Duration, opts ...Option) (m.Driver, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(maxWait)
+	backoff := initialConnectBackoff
+
+	for {
+		driver, err := NewWithConfig(ctx, config, opts...)
+		if err == nil || !isUnavailable(err) {
+			return driver, err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, err
+		}
+		if wait > backoff {
+			wait = backoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// isUnavailable reports whether err means the server can't be reached yet,
+// as opposed to refusing the connection for good.
+func isUnavailable(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound || dnsErr.IsTemporary
+	}
+
+	// cannot_connect_now is reported while the server starts up or recovers.
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57P03"
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DumpSchema returns a normalized description of the tables of the current
+// schema, with their columns and indexes, excluding the tables created by the
+// driver. Tables, columns and indexes are sorted by name, so that schemas
+// produced by different sequences of migrations compare equal when they have
+// the same definitions. It looks like:
+//
+//	TABLE users
+//	  COLUMN id integer NOT NULL
+//	  COLUMN name text DEFAULT ''::text
+//	  INDEX users_pkey CREATE UNIQUE INDEX users_pkey ON public.users USING btree (id)
+func (driver *Driver) DumpSchema(ctx context.Context) (string, error) {
+	conn, release, err := driver.acquireRead(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	own := map[string]bool{}
+	for _, table := range []string{driver.tableName, driver.historyTable(), driver.historyArchiveTable(), driver.repeatableTable(), driver.leaseTable(), driver.asyncTable()} {
+		own[table] = true
+	}
+
+	tables := map[string][]string{}
+
+	rows, err := conn.Query(ctx, `SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		LEFT JOIN pg_attrdef d ON d.adrelid = c.oid AND d.adnum = a.attnum
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p')
+		ORDER BY c.relname, a.attname`, driver.queryArgs()...)
+	if err != nil {
+		return "", closedErr(conn, err)
+	}
+	for rows.Next() {
+		var (
+			table, column, columnType, defaultValue string
+			notNull                                 bool
+		)
+		if err := rows.Scan(&table, &column, &columnType, &notNull, &defaultValue); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if own[table] {
+			continue
+		}
+
+		line := "COLUMN " + column + " " + columnType
+		if notNull {
+			line += " NOT NULL"
+		}
+		if defaultValue != "" {
+			line += " DEFAULT " + defaultValue
+		}
+		tables[table] = append(tables[table], line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	rows, err = conn.Query(ctx, "SELECT tablename, indexname, indexdef FROM pg_indexes WHERE schemaname = current_schema() ORDER BY tablename, indexname", driver.queryArgs()...)
+	if err != nil {
+		return "", closedErr(conn, err)
+	}
+	for rows.Next() {
+		var table, index, definition string
+		if err := rows.Scan(&table, &index, &definition); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if own[table] {
+			continue
+		}
+		tables[table] = append(tables[table], "INDEX "+index+" "+definition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, table := range names {
+		fmt.Fprintf(&b, "TABLE %s\n", table)
+		for _, line := range tables[table] {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	return b.String(), nil
+}
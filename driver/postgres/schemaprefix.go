@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/muxinc/migration/parser"
+)
+
+var (
+	// tableReferenceRegex matches the clauses naming a table, view, sequence
+	// or index, followed by the name, which is qualified if followed by a
+	// dot.
+	tableReferenceRegex = regexp.MustCompile(`(?i)\b(` +
+		`CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?|` +
+		`CREATE\s+(?:OR\s+REPLACE\s+)?(?:MATERIALIZED\s+)?VIEW\s+(?:IF\s+NOT\s+EXISTS\s+)?|` +
+		`CREATE\s+SEQUENCE\s+(?:IF\s+NOT\s+EXISTS\s+)?|` +
+		`CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:(?:"[^"]+"|\w+)\s+)?ON\s+(?:ONLY\s+)?|` +
+		`(?:ALTER|DROP)\s+(?:TABLE|(?:MATERIALIZED\s+)?VIEW|SEQUENCE|INDEX(?:\s+CONCURRENTLY)?)\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?|` +
+		`INSERT\s+INTO\s+|UPDATE\s+(?:ONLY\s+)?|DELETE\s+FROM\s+(?:ONLY\s+)?|TRUNCATE\s+(?:TABLE\s+)?(?:ONLY\s+)?|REFERENCES\s+` +
+		`)("[^"]+"|[A-Za-z_][A-Za-z0-9_$]*)(\s*\.)?`)
+
+	// notTableNames are the keywords that may follow a clause matched by
+	// tableReferenceRegex without naming a table, such as in
+	// "ON CONFLICT DO UPDATE SET" or "FOR UPDATE SKIP LOCKED".
+	notTableNames = map[string]bool{
+		"SET": true, "SKIP": true, "NOWAIT": true, "OF": true, "ON": true,
+		"OR": true, "CASCADE": true, "RESTRICT": true, "NO": true,
+	}
+
+	// bareIdentifierRegex matches identifiers that don't need quoting.
+	bareIdentifierRegex = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+)
+
+// schemaPrefixedStatements rewrites the unqualified table references of the
+// statements in sql, which may contain several statements, to be qualified
+// with schema. References inside string literals, dollar-quoted strings and
+// comments are left untouched.
+func schemaPrefixedStatements(sql, schema string) string {
+	prefix := schema
+	if !bareIdentifierRegex.MatchString(schema) {
+		prefix = pgx.Identifier{schema}.Sanitize()
+	}
+
+	var b strings.Builder
+	for _, statement := range parser.SplitStatements(sql) {
+		for _, segment := range splitCode(statement) {
+			if !segment.code {
+				b.WriteString(segment.text)
+				continue
+			}
+			b.WriteString(tableReferenceRegex.ReplaceAllStringFunc(segment.text, func(match string) string {
+				groups := tableReferenceRegex.FindStringSubmatch(match)
+				if groups[3] != "" || notTableNames[strings.ToUpper(groups[2])] {
+					return match
+				}
+				return groups[1] + prefix + "." + groups[2]
+			}))
+		}
+	}
+	return b.String()
+}
+
+// codeSegment is a part of a statement, which is either SQL code or a string
+// literal, quoted identifier, dollar-quoted string or comment.
+type codeSegment struct {
+	text string
+	code bool
+}
+
+// dollarTagRegex matches the opening tag of a dollar-quoted string.
+var dollarTagRegex = regexp.MustCompile(`^\$(?:[A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// splitCode splits statement into code and the literals and comments in
+// between. Quoted identifiers are kept within code, so that they can be
+// matched as names.
+func splitCode(statement string) []codeSegment {
+	var segments []codeSegment
+
+	start := 0
+	flush := func(end int, code bool) {
+		if end > start {
+			segments = append(segments, codeSegment{text: statement[start:end], code: code})
+		}
+		start = end
+	}
+
+	for i := 0; i < len(statement); {
+		var end int
+		switch {
+		case statement[i] == '"':
+			quote := strings.IndexByte(statement[i+1:], '"')
+			if quote < 0 {
+				i = len(statement)
+				continue
+			}
+			i += quote + 2
+			continue
+		case statement[i] == '\'':
+			end = i + 1
+			for end < len(statement) {
+				if statement[end] == '\'' {
+					if end+1 < len(statement) && statement[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					end++
+					break
+				}
+				end++
+			}
+		case strings.HasPrefix(statement[i:], "--"):
+			end = strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement)
+			} else {
+				end += i + 1
+			}
+		case strings.HasPrefix(statement[i:], "/*"):
+			end = strings.Index(statement[i:], "*/")
+			if end < 0 {
+				end = len(statement)
+			} else {
+				end += i + 2
+			}
+		case statement[i] == '$' && dollarTagRegex.MatchString(statement[i:]):
+			tag := dollarTagRegex.FindString(statement[i:])
+			end = strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				end = len(statement)
+			} else {
+				end += i + 2*len(tag)
+			}
+		default:
+			i++
+			continue
+		}
+
+		flush(i, true)
+		flush(end, false)
+		i = end
+	}
+	flush(len(statement), true)
+
+	return segments
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	m "github.com/muxinc/migration"
+)
+
+// MigrateSchemas runs migration against each of schemas within a single
+// transaction, so that either every schema is migrated or none is, for
+// example to keep schema-per-tenant databases consistent. The search_path is
+// set to each schema in turn, so the unqualified names used by the migration
+// resolve to that schema, and the version is recorded in a version table of
+// its own in each schema, which is created if needed.
+//
+// The migration must use a transaction. If it fails in any schema, the
+// changes made to all schemas are rolled back.
+func (driver *Driver) MigrateSchemas(ctx context.Context, schemas []string, migration *m.PlannedMigration) (err error) {
+	if migration.Direction != m.Up && migration.Direction != m.Down {
+		return &m.InvalidDirectionError{Direction: migration.Direction}
+	}
+
+	statements := statementsFor(migration)
+	if statements == nil {
+		return fmt.Errorf("migration %s has no %s migration", migration.ID, migration.Direction)
+	}
+	if !statements.UseTransaction {
+		return fmt.Errorf("migration %s does not use a transaction and cannot be applied to several schemas atomically", migration.ID)
+	}
+	if err := checkTransactional(migration); err != nil {
+		return err
+	}
+
+	ctx, done, err := driver.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(&err)
+
+	conn, release, err := driver.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer func() {
+		err = closedErr(conn, err)
+	}()
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, schema := range schemas {
+			if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+pgx.Identifier{schema}.Sanitize()); err != nil {
+				return fmt.Errorf("error setting search_path to schema %s: %s", schema, err)
+			}
+
+			if err := driver.createVersionTable(ctx, tx); err != nil {
+				return fmt.Errorf("error creating version table in schema %s: %w", schema, err)
+			}
+
+			if err := driver.checkApplied(ctx, tx, migration); err != nil {
+				return fmt.Errorf("error applying migration %s to schema %s: %w", migration.ID, schema, err)
+			}
+
+			if err := driver.execMigrationStatements(ctx, tx, migration, true); err != nil {
+				return fmt.Errorf("error applying migration %s to schema %s: %w", migration.ID, schema, err)
+			}
+
+			if err := driver.updateVersion(ctx, tx, migration); err != nil {
+				return fmt.Errorf("error recording migration %s in schema %s: %w", migration.ID, schema, err)
+			}
+		}
+		return nil
+	})
+}
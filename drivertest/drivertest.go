@@ -0,0 +1,141 @@
+// Package drivertest provides a conformance suite for migration.Driver
+// implementations.
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/muxinc/migration"
+)
+
+// testLogger logs to the test's log.
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Printf(format string, v ...interface{}) {
+	l.t.Logf(format, v...)
+}
+
+// RunConformance runs a standardized suite against a driver: applying
+// migrations up and down, reporting versions in order, not reapplying
+// migrations and failing on a bad statement.
+//
+// newDriver must return a new driver connected to the same, initially empty,
+// database each time it is called, as migration.Migrate closes the driver it
+// is given. The suite uses simple SQL statements understood by most SQL
+// databases, and drops the tables it creates on success.
+func RunConformance(t *testing.T, newDriver func(ctx context.Context) migration.Driver) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	l := testLogger{t: t}
+
+	// 10_third sorts before 2_second as text, but after it canonically.
+	source := &migration.MemoryMigrationSource{
+		Files: map[string]string{
+			"1_first.up.sql":    "CREATE TABLE drivertest_table1 (id integer not null primary key);",
+			"1_first.down.sql":  "DROP TABLE drivertest_table1;",
+			"2_second.up.sql":   "CREATE TABLE drivertest_table2 (id integer not null primary key);",
+			"2_second.down.sql": "DROP TABLE drivertest_table2;",
+			"10_third.up.sql":   "CREATE TABLE drivertest_table3 (id integer not null primary key);",
+			"10_third.down.sql": "DROP TABLE drivertest_table3;",
+		},
+	}
+
+	versions := func(t *testing.T) []string {
+		t.Helper()
+
+		driver := newDriver(ctx)
+		defer driver.Close(ctx)
+
+		versions, err := driver.Versions(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error while retrieving versions: %s", err)
+		}
+		return versions
+	}
+
+	migrate := func(t *testing.T, source migration.Source, direction migration.Direction, max int, expected int) {
+		t.Helper()
+
+		applied, err := migration.Migrate(ctx, newDriver(ctx), source, direction, max, l)
+		if err != nil {
+			t.Fatalf("unexpected error while migrating %s: %s", direction, err)
+		}
+		if applied != expected {
+			t.Fatalf("expected %d migrations to be applied %s, got %d", expected, direction, applied)
+		}
+	}
+
+	steps := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"Up", func(t *testing.T) {
+			migrate(t, source, migration.Up, 0, 3)
+		}},
+		{"VersionsOrdering", func(t *testing.T) {
+			expected := []string{"1_first", "2_second", "10_third"}
+			if actual := versions(t); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected versions to be %v, got %v", expected, actual)
+			}
+		}},
+		{"Idempotency", func(t *testing.T) {
+			migrate(t, source, migration.Up, 0, 0)
+		}},
+		{"Down", func(t *testing.T) {
+			migrate(t, source, migration.Down, 1, 1)
+
+			expected := []string{"1_first", "2_second"}
+			if actual := versions(t); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected versions to be %v after rolling back, got %v", expected, actual)
+			}
+		}},
+		{"BadStatement", func(t *testing.T) {
+			bad := &migration.MemoryMigrationSource{Files: map[string]string{}}
+			for file, contents := range source.Files {
+				bad.Files[file] = contents
+			}
+			bad.Files["11_bad.up.sql"] = "THIS IS NOT A VALID STATEMENT;"
+			bad.Files["11_bad.down.sql"] = ""
+
+			driver := newDriver(ctx)
+			applied, err := migration.Migrate(ctx, driver, bad, migration.Up, 0, l)
+			if err != nil {
+				// Migrate leaves the driver open when a migration fails.
+				driver.Close(ctx)
+			}
+
+			var migrationErr *migration.MigrationError
+			if !errors.As(err, &migrationErr) || migrationErr.ID != "11_bad" {
+				t.Fatalf("expected a migration error for 11_bad, got %v", err)
+			}
+			if applied != 1 {
+				t.Errorf("expected the migration preceding the bad one to be applied, got %d", applied)
+			}
+
+			expected := []string{"1_first", "2_second", "10_third"}
+			if actual := versions(t); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected versions to be %v after a failed migration, got %v", expected, actual)
+			}
+		}},
+		{"DownAll", func(t *testing.T) {
+			migrate(t, source, migration.Down, 0, 3)
+
+			if actual := versions(t); len(actual) != 0 {
+				t.Errorf("expected no versions after rolling back everything, got %v", actual)
+			}
+		}},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, step.run) {
+			return
+		}
+	}
+}
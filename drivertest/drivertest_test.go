@@ -0,0 +1,58 @@
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/muxinc/migration"
+)
+
+// memoryDriver records versions in memory and fails on statements containing
+// "NOT A VALID".
+type memoryDriver struct {
+	applied *[]string
+}
+
+func (d memoryDriver) Close(ctx context.Context) error {
+	return nil
+}
+
+func (d memoryDriver) Migrate(ctx context.Context, planned *migration.PlannedMigration) error {
+	statements := planned.Up
+	if planned.Direction == migration.Down {
+		statements = planned.Down
+	}
+
+	for _, statement := range statements.Statements {
+		if strings.Contains(statement, "NOT A VALID") {
+			return errors.New("syntax error")
+		}
+	}
+
+	if planned.Direction == migration.Up {
+		*d.applied = append(*d.applied, planned.ID)
+		return nil
+	}
+
+	for i, id := range *d.applied {
+		if id == planned.ID {
+			*d.applied = append((*d.applied)[:i], (*d.applied)[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (d memoryDriver) Versions(ctx context.Context) ([]string, error) {
+	return append([]string{}, *d.applied...), nil
+}
+
+func TestRunConformance(t *testing.T) {
+	applied := []string{}
+
+	RunConformance(t, func(ctx context.Context) migration.Driver {
+		return memoryDriver{applied: &applied}
+	})
+}
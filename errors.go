@@ -0,0 +1,180 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MigrationError is returned when a migration fails to apply.
+type MigrationError struct {
+	ID        string
+	Direction Direction
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("Error while running migration %s (%s): %s", e.ID, e.Direction, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationErrors is returned when WithContinueOnMigrationError is used and
+// one or more migrations failed.
+type MigrationErrors []*MigrationError
+
+func (e MigrationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d migrations failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// GapError is returned when unapplied migrations precede an applied migration
+// and out-of-order migrations are not allowed.
+type GapError struct {
+	IDs []string
+}
+
+func (e *GapError) Error() string {
+	return fmt.Sprintf("unapplied migrations precede the last applied migration: %s", strings.Join(e.IDs, ", "))
+}
+
+// LockTimeoutError is returned by a Locker when the migration lock could not
+// be acquired in time, usually because another process is migrating.
+type LockTimeoutError struct {
+	Name    string
+	Timeout time.Duration
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for migration lock %q", e.Timeout, e.Name)
+}
+
+// MissingMigrationError is returned when rolling back a migration that has
+// been applied but is not in the migration set, so its down migration is
+// unknown.
+type MissingMigrationError struct {
+	ID string
+}
+
+func (e *MissingMigrationError) Error() string {
+	return fmt.Sprintf("migration %s is applied but not in the migration set, so it cannot be rolled back", e.ID)
+}
+
+// MissingDownError is returned when WithStrictLoad is used and a migration
+// has no down migration without being marked irreversible.
+type MissingDownError struct {
+	ID string
+}
+
+func (e *MissingDownError) Error() string {
+	return fmt.Sprintf("migration %s has no down migration: add one, or mark the up migration with \"-- +migration Irreversible\"", e.ID)
+}
+
+// NoMigrationsError is returned when WithRequireMigrations is used and the
+// source contains no migrations.
+type NoMigrationsError struct{}
+
+func (e *NoMigrationsError) Error() string {
+	return "no migrations found in the migration source"
+}
+
+// MissingDependencyError is returned when a migration requires a migration
+// that is not in the migration set.
+type MissingDependencyError struct {
+	ID       string
+	Requires string
+}
+
+func (e *MissingDependencyError) Error() string {
+	return fmt.Sprintf("migration %s requires %s, which is not in the migration set", e.ID, e.Requires)
+}
+
+// DependencyCycleError is returned when the requirements of migrations form a
+// cycle, so they cannot be ordered. IDs lists the migrations in or depending
+// on the cycle.
+type DependencyCycleError struct {
+	IDs []string
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("migration requirements form a cycle: %s", strings.Join(e.IDs, ", "))
+}
+
+// AmbiguousIDError is returned when a prefix given to ResolveID matches more
+// than one migration.
+type AmbiguousIDError struct {
+	Prefix string
+	IDs    []string
+}
+
+func (e *AmbiguousIDError) Error() string {
+	return fmt.Sprintf("%q matches several migrations: %s", e.Prefix, strings.Join(e.IDs, ", "))
+}
+
+// DownNotAllowedError is returned when migrating down in the production
+// environment without WithAllowDownInProduction.
+type DownNotAllowedError struct {
+	Environment string
+}
+
+func (e *DownNotAllowedError) Error() string {
+	return fmt.Sprintf("refusing to migrate down in the %s environment, use WithAllowDownInProduction to allow it", e.Environment)
+}
+
+// ChecksumMismatchError is returned when applied migrations have been edited
+// since they were applied, and WithChecksumMode does not allow it.
+type ChecksumMismatchError struct {
+	IDs []string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("applied migrations have been edited since they were applied: %s", strings.Join(e.IDs, ", "))
+}
+
+// PostVerifyError is returned when a verification query of a migration fails
+// after the migration was applied, see Migration.Verify. The migration stays
+// recorded as applied. Err is the error running the query, or nil if the
+// query ran but did not pass.
+type PostVerifyError struct {
+	ID    string
+	Query string
+	Err   error
+}
+
+func (e *PostVerifyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("migration %s was applied but its verification query failed: %s\n%s", e.ID, e.Err, e.Query)
+	}
+	return fmt.Sprintf("migration %s was applied but its verification query did not pass:\n%s", e.ID, e.Query)
+}
+
+func (e *PostVerifyError) Unwrap() error {
+	return e.Err
+}
+
+// ServerVersionError is returned when a planned migration requires a newer
+// database server than the one the driver is connected to.
+type ServerVersionError struct {
+	ID       string
+	Required string
+	Actual   string
+}
+
+func (e *ServerVersionError) Error() string {
+	return fmt.Sprintf("migration %s requires server version %s or later, but the server runs %s", e.ID, e.Required, e.Actual)
+}
+
+// InvalidDirectionError is returned when a direction is neither Up nor Down,
+// for example because it was converted from an out of range integer.
+type InvalidDirectionError struct {
+	Direction Direction
+}
+
+func (e *InvalidDirectionError) Error() string {
+	return fmt.Sprintf("invalid migration direction %d, expected Up or Down", int(e.Direction))
+}
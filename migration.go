@@ -3,11 +3,15 @@ package migration
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"text/template"
 
 	"github.com/muxinc/migration/parser"
 )
@@ -27,12 +31,17 @@ func (d Direction) String() string {
 	}
 }
 
-// Constants for direction
+// Constants for direction. The zero value is Up, so a PlannedMigration whose
+// direction is left unset is applied up.
 const (
 	Up Direction = iota
 	Down
 )
 
+// productionEnvironment is the environment in which migrating down is refused
+// by default. See WithEnvironment.
+const productionEnvironment = "production"
+
 var numberPrefixRegex = regexp.MustCompile(`^(\d+).*$`)
 
 // Migration represents a migration, containing statements for migrating up and down.
@@ -40,6 +49,59 @@ type Migration struct {
 	ID   string
 	Up   *parser.ParsedMigration
 	Down *parser.ParsedMigration
+
+	// Description is the description of the up migration, falling back to
+	// the down migration's.
+	Description string
+
+	// Requires lists the IDs of migrations that must be applied before this
+	// one, regardless of ID order. Migrations are ordered topologically when
+	// any migration declares requirements, and by ID otherwise.
+	Requires []string
+
+	// Tags are free-form labels declared in the front-matter of the up
+	// migration, falling back to the down migration's, for callers to select
+	// or report on migrations by.
+	Tags []string
+
+	// Guard is an optional query, read from a "<id>.guard.sql" file, that
+	// must pass for the migration to be applied. It passes if it returns a
+	// row whose first column is neither false nor NULL. Migrations whose
+	// guard doesn't pass are skipped with a logged reason and stay pending,
+	// so they are reconsidered by the next run. Guards only apply to the up
+	// direction, are evaluated by drivers implementing GuardEvaluator, and
+	// are not evaluated by Plan.
+	Guard *parser.ParsedMigration
+
+	// PostHook lists maintenance statements, read from a "<id>.post.sql"
+	// file, that drivers supporting them run after applying the migration
+	// up, outside of its transaction, for example ANALYZE or VACUUM after a
+	// backfill. They are not part of the recorded version, and drivers
+	// report their failures as warnings rather than failing the migration.
+	PostHook []string
+
+	// Verify lists queries, read from a "<id>.verify.sql" file, that check
+	// the migration had the intended effect after it is applied up, such as
+	// a new column existing with the expected default. Like guards, each
+	// query passes if it returns a row whose first column is neither false
+	// nor NULL, and is evaluated by drivers implementing GuardEvaluator. A
+	// failing query stops the run with a PostVerifyError; the migration
+	// stays recorded as applied.
+	Verify []string
+
+	// Repeatable is set for repeatable migrations, read from "R__<name>.sql"
+	// files, which have no version. They are applied after all versioned
+	// migrations when migrating up with no maximum, whenever their Checksum
+	// differs from the one recorded when they were last applied. They only
+	// have an up migration.
+	Repeatable bool
+
+	// sourceUp and sourceDown are the up and down migrations as parsed from
+	// the files the migration was loaded from, before templating, and
+	// without a down migration generated by WithAutoDown. Checksum hashes
+	// them rather than Up and Down when sourced is set.
+	sourceUp, sourceDown *parser.ParsedMigration
+	sourced              bool
 }
 
 // PlannedMigration is a migration with a direction defined. This allows the driver to
@@ -47,19 +109,41 @@ type Migration struct {
 type PlannedMigration struct {
 	*Migration
 	Direction Direction
+
+	// Metadata is stored alongside the version by drivers that support it,
+	// for example the deploy SHA or the operator applying the migration.
+	Metadata map[string]string
 }
 
 // Less compares two migrations to determine how they should be ordered.
 func (m Migration) Less(other *Migration) bool {
+	return compareMigrations(m, *other) < 0
+}
+
+// CompareIDs compares two migration IDs in canonical order, returning -1 if a
+// sorts before b, 1 if it sorts after b and 0 if they are equal. IDs with a
+// numeric prefix sort by that number and before IDs without one; other IDs
+// sort lexically. IDs are compared as exact bytes, so IDs differing only in
+// case, such as 1_Foo and 1_foo, are distinct, with upper case sorting first.
+// Drivers should use it rather than relying on the collation of their
+// backend, and store IDs so that they stay distinct.
+func CompareIDs(a, b string) int {
+	return compareMigrations(Migration{ID: a}, Migration{ID: b})
+}
+
+func compareMigrations(m, other Migration) int {
 	switch {
 	case m.isNumeric() && other.isNumeric() && m.VersionInt() != other.VersionInt():
-		return m.VersionInt() < other.VersionInt()
+		if m.VersionInt() < other.VersionInt() {
+			return -1
+		}
+		return 1
 	case m.isNumeric() && !other.isNumeric():
-		return true
+		return -1
 	case !m.isNumeric() && other.isNumeric():
-		return false
+		return 1
 	default:
-		return m.ID < other.ID
+		return strings.Compare(m.ID, other.ID)
 	}
 }
 
@@ -92,63 +176,996 @@ type Logger interface {
 	Printf(format string, v ...interface{})
 }
 
-// Migrate runs a migration using a given driver and MigrationSource. The direction defines whether
-// the migration is up or down, and max is the maximum number of migrations to apply. If max is set to 0,
-// then there is no limit on the number of migrations to apply. Logs are sent to Logger.
-//
-// If ctx is cancelled before all migrations have completed, any active or
-// remaining migrations will be cancelled.
-func Migrate(ctx context.Context, driver Driver, migrations Source, direction Direction, max int, l Logger) (int, error) {
-	count := 0
+// Migrate runs a migration using a given driver and MigrationSource. The direction defines whether
+// the migration is up or down, and max is the maximum number of migrations to apply. If max is set to 0,
+// then there is no limit on the number of migrations to apply. Logs are sent to Logger, unless it is nil.
+//
+// If ctx is cancelled before all migrations have completed, any active or
+// remaining migrations will be cancelled.
+//
+// If the driver implements Locker, the lock is held while migrations are
+// planned and applied.
+//
+// If there is nothing to migrate, "No pending migrations" is logged and 0 is
+// returned.
+func Migrate(ctx context.Context, driver Driver, migrations Source, direction Direction, max int, l Logger, opts ...Option) (int, error) {
+	return run(ctx, driver, migrations, direction, max, l, newOptions(opts), nil)
+}
+
+// run implements Migrate, filling in result if it is not nil.
+func run(ctx context.Context, driver Driver, migrations Source, direction Direction, max int, l Logger, o *options, result *RunResult) (int, error) {
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return 0, err
+	}
+
+	repeatables, err := getRepeatables(migrations, o)
+	if err != nil {
+		return 0, err
+	}
+
+	locker, ok := driver.(Locker)
+	if ok {
+		if err = locker.Lock(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	if result != nil {
+		if result.StartVersion, err = latestVersion(ctx, driver); err != nil {
+			if ok {
+				locker.Unlock(context.Background())
+			}
+			return 0, err
+		}
+	}
+
+	count, err := migrate(ctx, driver, m, direction, max, l, o, result)
+
+	if err == nil && direction == Up && max == 0 {
+		var applied []string
+		applied, err = migrateRepeatables(ctx, driver, repeatables, l, o)
+		count += len(applied)
+		if result != nil {
+			result.Applied = append(result.Applied, applied...)
+		}
+	}
+
+	if result != nil {
+		var errVersion error
+		if result.EndVersion, errVersion = latestVersion(ctx, driver); errVersion != nil && err == nil {
+			err = errVersion
+		}
+	}
+
+	if ok {
+		if errUnlock := locker.Unlock(context.Background()); errUnlock != nil && err == nil {
+			err = errUnlock
+		}
+	}
+
+	var failed MigrationErrors
+	if err != nil && !errors.As(err, &failed) {
+		return count, err
+	}
+
+	if errClose := driver.Close(context.Background()); errClose != nil {
+		return count, errClose
+	}
+	return count, err
+}
+
+// MigrateUp applies all pending migrations, bringing the database to the
+// latest version. It returns the number of migrations applied.
+func MigrateUp(ctx context.Context, driver Driver, migrations Source, opts ...Option) (int, error) {
+	return Migrate(ctx, driver, migrations, Up, 0, nil, opts...)
+}
+
+// MigrateDown rolls back all applied migrations. It returns the number of
+// migrations rolled back. Use Migrate with a maximum to roll back fewer.
+func MigrateDown(ctx context.Context, driver Driver, migrations Source, opts ...Option) (int, error) {
+	return Migrate(ctx, driver, migrations, Down, 0, nil, opts...)
+}
+
+// Plan returns the migrations that Migrate would apply with the same
+// arguments, in order, without applying them.
+func Plan(ctx context.Context, driver Driver, migrations Source, direction Direction, max int, opts ...Option) ([]*PlannedMigration, error) {
+	o := newOptions(opts)
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return nil, err
+	}
+
+	planned, err := plan(ctx, driver, m, direction, max, nil, o)
+	if err != nil || direction != Up || max != 0 {
+		return planned, err
+	}
+
+	repeatables, err := getRepeatables(migrations, o)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := planRepeatables(ctx, driver, repeatables)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(planned, changed...), nil
+}
+
+// RollbackPlan returns the n most recently applied migrations, planned down in
+// the reverse of the order they were applied, without rolling anything back.
+// It is the down counterpart of Plan, previewing what Migrate would roll
+// back. If n is 0, every applied migration is returned. The application order
+// is the one reported by the driver's Versions.
+//
+// Unlike Plan, it ignores unapplied migrations preceding the latest applied
+// one, which Migrate would apply before rolling back.
+func RollbackPlan(ctx context.Context, driver Driver, migrations Source, n int, opts ...Option) ([]*PlannedMigration, error) {
+	o := newOptions(opts)
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	toApply := toRollback(m, appliedMigrations)
+	if n > 0 && n < len(toApply) {
+		toApply = toApply[:n]
+	}
+
+	planned := make([]*PlannedMigration, 0, len(toApply))
+	for _, migration := range toApply {
+		planned = append(planned, &PlannedMigration{Migration: migration, Direction: Down})
+	}
+
+	return handleMissingDown(m, planned, nil, o)
+}
+
+func plan(ctx context.Context, driver Driver, m []*Migration, direction Direction, max int, l Logger, o *options) ([]*PlannedMigration, error) {
+	if direction != Up && direction != Down {
+		return nil, &InvalidDirectionError{Direction: direction}
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.strictOrder {
+		if ids := gaps(m, appliedMigrations); len(ids) > 0 {
+			return nil, &GapError{IDs: ids}
+		}
+	}
+
+	migrationsToApply := planMigrations(m, appliedMigrations, direction, max)
+
+	if direction == Down {
+		return handleMissingDown(m, migrationsToApply, l, o)
+	}
+
+	return migrationsToApply, nil
+}
+
+func migrate(ctx context.Context, driver Driver, m []*Migration, direction Direction, max int, l Logger, o *options, result *RunResult) (count int, err error) {
+	var migrationsToApply []*PlannedMigration
+
+	if result != nil {
+		defer func() {
+			result.Applied = appliedIDs(migrationsToApply, count, err)
+		}()
+	}
+
+	if direction == Down {
+		if err := checkDownAllowed(o); err != nil {
+			return count, err
+		}
+	}
+
+	if err := verifyChecksums(ctx, driver, m, l, o); err != nil {
+		return count, err
+	}
+
+	migrationsToApply, err = plan(ctx, driver, m, direction, max, l, o)
+	if err != nil {
+		return count, err
+	}
+
+	var skipped []string
+	if migrationsToApply, skipped, err = evaluateGuards(ctx, driver, migrationsToApply, l); err != nil {
+		return count, err
+	}
+	if result != nil {
+		result.Skipped = skipped
+	}
+
+	if len(migrationsToApply) == 0 {
+		logPrintf(l, "No pending migrations (%s)", direction.String())
+		return count, nil
+	}
+
+	if err := checkServerVersion(ctx, driver, migrationsToApply); err != nil {
+		return count, err
+	}
+
+	if o.preValidate {
+		if err := preValidate(ctx, driver, migrationsToApply, l); err != nil {
+			return count, err
+		}
+	}
+
+	if o.singleTransaction || o.savepoints || o.commitEvery > 1 || o.batchStatements > 0 {
+		if migrationsToApply, err = confirmAll(migrationsToApply, l, o); err != nil {
+			return count, err
+		}
+		if len(migrationsToApply) == 0 {
+			return count, nil
+		}
+	}
+
+	if o.savepoints {
+		return migrateWithSavepoints(ctx, driver, migrationsToApply, direction, l, o)
+	}
+
+	if o.singleTransaction {
+		return migrateSingleTransaction(ctx, driver, migrationsToApply, direction, l, o)
+	}
+
+	if o.commitEvery > 1 || o.batchStatements > 0 {
+		return migrateInGroups(ctx, driver, migrationsToApply, direction, l, o)
+	}
+
+	var failed MigrationErrors
+
+	for _, plannedMigration := range migrationsToApply {
+		var confirmed bool
+		if confirmed, err = confirm(plannedMigration, l, o); err != nil {
+			return count, err
+		}
+		if !confirmed {
+			break
+		}
+
+		logPrintf(l, "Applying migration (%s) named '%s'...", direction.String(), plannedMigration.ID)
+
+		err = driver.Migrate(ctx, plannedMigration)
+		if err != nil {
+			migrationErr := &MigrationError{
+				ID:        plannedMigration.ID,
+				Direction: plannedMigration.Direction,
+				Err:       err,
+			}
+
+			if !o.continueOnError {
+				return count, migrationErr
+			}
+
+			logPrintf(l, "%s, continuing with the next migration", migrationErr)
+			failed = append(failed, migrationErr)
+			continue
+		}
+
+		logPrintf(l, "Applied migration (%s) named '%s'", direction.String(), plannedMigration.ID)
+		count++
+
+		if err = verifyMigrations(ctx, driver, []*PlannedMigration{plannedMigration}, l); err != nil {
+			return count, err
+		}
+	}
+
+	if len(failed) > 0 {
+		return count, failed
+	}
+	return count, nil
+}
+
+// verifyChecksums compares the checksums recorded for applied migrations with
+// their current checksums, as configured by WithChecksumMode.
+func verifyChecksums(ctx context.Context, driver Driver, m []*Migration, l Logger, o *options) error {
+	lister, ok := driver.(AppliedMigrationLister)
+	if !ok || o.checksumMode == ChecksumOff {
+		return nil
+	}
+
+	applied, err := lister.AppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]*Migration{}
+	for _, migration := range m {
+		loaded[migration.ID] = migration
+	}
+
+	var mismatched []string
+	for _, appliedMigration := range applied {
+		migration, ok := loaded[appliedMigration.ID]
+		if !ok || appliedMigration.Checksum == "" {
+			continue
+		}
+
+		if checksum := migration.Checksum(); checksum != appliedMigration.Checksum {
+			if o.checksumMode == ChecksumWarn {
+				logPrintf(l, "Warning: migration '%s' has been edited since it was applied (checksum %s, now %s)", migration.ID, appliedMigration.Checksum, checksum)
+			}
+			mismatched = append(mismatched, migration.ID)
+		}
+	}
+
+	if len(mismatched) > 0 && o.checksumMode == ChecksumError {
+		return &ChecksumMismatchError{IDs: mismatched}
+	}
+
+	return nil
+}
+
+// preValidate checks the statements of each planned migration using the
+// driver's Validator, returning a MigrationError for the first migration that
+// fails validation.
+func preValidate(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration, l Logger) error {
+	validator, ok := driver.(Validator)
+	if !ok {
+		logPrintf(l, "Driver %T cannot validate statements, applying migrations without validation", driver)
+		return nil
+	}
+
+	for _, plannedMigration := range migrationsToApply {
+		statements := statementsFor(plannedMigration)
+		if statements == nil {
+			continue
+		}
+
+		if err := validator.ValidateStatements(ctx, statements); err != nil {
+			return &MigrationError{
+				ID:        plannedMigration.ID,
+				Direction: plannedMigration.Direction,
+				Err:       fmt.Errorf("validation failed: %w", err),
+			}
+		}
+	}
+
+	return nil
+}
+
+// confirm asks the callback set with WithConfirm, if any, whether to apply
+// plannedMigration, logging when it is declined.
+func confirm(plannedMigration *PlannedMigration, l Logger, o *options) (bool, error) {
+	if o.confirm == nil {
+		return true, nil
+	}
+
+	confirmed, err := o.confirm(plannedMigration)
+	if err != nil {
+		return false, &MigrationError{
+			ID:        plannedMigration.ID,
+			Direction: plannedMigration.Direction,
+			Err:       fmt.Errorf("error confirming migration: %w", err),
+		}
+	}
+	if !confirmed {
+		logPrintf(l, "Migration (%s) named '%s' was not confirmed, stopping", plannedMigration.Direction.String(), plannedMigration.ID)
+	}
+	return confirmed, nil
+}
+
+// confirmAll confirms each planned migration in turn, returning those before
+// the first one that is declined.
+func confirmAll(migrationsToApply []*PlannedMigration, l Logger, o *options) ([]*PlannedMigration, error) {
+	for i, plannedMigration := range migrationsToApply {
+		confirmed, err := confirm(plannedMigration, l, o)
+		if err != nil {
+			return nil, err
+		}
+		if !confirmed {
+			return migrationsToApply[:i], nil
+		}
+	}
+	return migrationsToApply, nil
+}
+
+// verifyMigrations evaluates the verification queries of the migrations that
+// were applied up, returning a PostVerifyError for the first query that fails.
+func verifyMigrations(ctx context.Context, driver Driver, applied []*PlannedMigration, l Logger) error {
+	for _, plannedMigration := range applied {
+		if plannedMigration.Direction != Up || len(plannedMigration.Verify) == 0 {
+			continue
+		}
+
+		evaluator, ok := driver.(GuardEvaluator)
+		if !ok {
+			return fmt.Errorf("driver %T cannot evaluate the verification queries of migration %s", driver, plannedMigration.ID)
+		}
+
+		for _, query := range plannedMigration.Verify {
+			pass, err := evaluator.EvaluateGuard(ctx, &parser.ParsedMigration{UseTransaction: true, Statements: []string{query}})
+			if err != nil || !pass {
+				return &PostVerifyError{ID: plannedMigration.ID, Query: query, Err: err}
+			}
+		}
+
+		logPrintf(l, "Verified migration (%s) named '%s'", plannedMigration.Direction.String(), plannedMigration.ID)
+	}
+
+	return nil
+}
+
+// evaluateGuards removes the planned migrations whose guard doesn't pass,
+// logging why they are skipped, and returns the IDs of skipped migrations.
+func evaluateGuards(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration, l Logger) ([]*PlannedMigration, []string, error) {
+	var (
+		guarded []*PlannedMigration
+		skipped []string
+	)
+
+	for _, plannedMigration := range migrationsToApply {
+		if plannedMigration.Direction != Up || plannedMigration.Guard == nil {
+			guarded = append(guarded, plannedMigration)
+			continue
+		}
+
+		evaluator, ok := driver.(GuardEvaluator)
+		if !ok {
+			return nil, nil, fmt.Errorf("driver %T cannot evaluate the guard of migration %s", driver, plannedMigration.ID)
+		}
+
+		pass, err := evaluator.EvaluateGuard(ctx, plannedMigration.Guard)
+		if err != nil {
+			return nil, nil, &MigrationError{
+				ID:        plannedMigration.ID,
+				Direction: plannedMigration.Direction,
+				Err:       fmt.Errorf("error evaluating guard: %w", err),
+			}
+		}
+		if !pass {
+			logPrintf(l, "Skipping migration (%s) named '%s': its guard did not pass", plannedMigration.Direction.String(), plannedMigration.ID)
+			skipped = append(skipped, plannedMigration.ID)
+			continue
+		}
+
+		guarded = append(guarded, plannedMigration)
+	}
+
+	return guarded, skipped, nil
+}
+
+// handleMissingDown removes planned rollbacks of applied migrations that are
+// not in m, as they have no down migration to run. Unless
+// WithSkipMissingDown is used, a MissingMigrationError is returned instead.
+func handleMissingDown(m []*Migration, migrationsToApply []*PlannedMigration, l Logger, o *options) ([]*PlannedMigration, error) {
+	loaded := map[string]bool{}
+	for _, migration := range m {
+		loaded[migration.ID] = true
+	}
+
+	var result []*PlannedMigration
+	for _, plannedMigration := range migrationsToApply {
+		if plannedMigration.Direction != Down || loaded[plannedMigration.ID] {
+			result = append(result, plannedMigration)
+			continue
+		}
+
+		if !o.skipMissingDown {
+			return nil, &MissingMigrationError{ID: plannedMigration.ID}
+		}
+
+		logPrintf(l, "Skipping rollback of migration '%s', which is applied but not in the migration set", plannedMigration.ID)
+	}
+
+	return result, nil
+}
+
+func migrateSingleTransaction(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration, direction Direction, l Logger, o *options) (int, error) {
+	if o.continueOnError {
+		return 0, errors.New("single transaction mode cannot be combined with continuing on migration errors")
+	}
+
+	if !supportsTransactionalDDL(driver) {
+		return 0, fmt.Errorf("driver %T does not support transactional DDL, refusing to run migrations in a single transaction", driver)
+	}
+
+	batcher, ok := driver.(Batcher)
+	if !ok {
+		return 0, fmt.Errorf("driver %T does not support running migrations in a single transaction", driver)
+	}
+
+	logPrintf(l, "Applying %d migrations (%s) in a single transaction...", len(migrationsToApply), direction.String())
+
+	if err := batcher.MigrateBatch(ctx, migrationsToApply); err != nil {
+		return 0, fmt.Errorf("Error while running migrations in a single transaction: %w", err)
+	}
+
+	logPrintf(l, "Applied %d migrations (%s) in a single transaction", len(migrationsToApply), direction.String())
+
+	return len(migrationsToApply), verifyMigrations(ctx, driver, migrationsToApply, l)
+}
+
+// migrateWithSavepoints applies migrationsToApply in a single transaction,
+// each in its own savepoint, see WithSavepoints.
+func migrateWithSavepoints(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration, direction Direction, l Logger, o *options) (int, error) {
+	if o.singleTransaction || o.commitEvery > 1 || o.batchStatements > 0 {
+		return 0, errors.New("savepoint mode cannot be combined with WithSingleTransaction, WithCommitEvery or WithBatchSmallMigrations")
+	}
+
+	if !supportsTransactionalDDL(driver) {
+		return 0, fmt.Errorf("driver %T does not support transactional DDL, refusing to run migrations in a single transaction", driver)
+	}
+
+	batcher, ok := driver.(SavepointBatcher)
+	if !ok {
+		return 0, fmt.Errorf("driver %T does not support running migrations in savepoints", driver)
+	}
+
+	logPrintf(l, "Applying %d migrations (%s) in a single transaction with savepoints...", len(migrationsToApply), direction.String())
+
+	var failed MigrationErrors
+	stopped := false
+
+	err := batcher.MigrateWithSavepoints(ctx, migrationsToApply, func(plannedMigration *PlannedMigration, err error) bool {
+		migrationErr := &MigrationError{
+			ID:        plannedMigration.ID,
+			Direction: plannedMigration.Direction,
+			Err:       err,
+		}
+		failed = append(failed, migrationErr)
+
+		if !o.continueOnError {
+			logPrintf(l, "%s, rolled back to its savepoint", migrationErr)
+			stopped = true
+			return false
+		}
+
+		logPrintf(l, "%s, rolled back to its savepoint and continuing with the next migration", migrationErr)
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error while running migrations in a single transaction: %w", err)
+	}
+
+	failedIDs := map[string]bool{}
+	for _, migrationErr := range failed {
+		failedIDs[migrationErr.ID] = true
+	}
+
+	var applied []*PlannedMigration
+	for _, plannedMigration := range migrationsToApply {
+		if failedIDs[plannedMigration.ID] {
+			if stopped {
+				break
+			}
+			continue
+		}
+		applied = append(applied, plannedMigration)
+	}
+
+	logPrintf(l, "Applied %d migrations (%s) in a single transaction with savepoints", len(applied), direction.String())
+
+	if err := verifyMigrations(ctx, driver, applied, l); err != nil {
+		return len(applied), err
+	}
+
+	switch {
+	case stopped:
+		return len(applied), failed[0]
+	case len(failed) > 0:
+		return len(applied), failed
+	}
+	return len(applied), nil
+}
+
+// migrateInGroups applies consecutive migrations that use a transaction in
+// shared transactions of up to o.commitEvery migrations and, if set,
+// o.batchStatements statements. Migrations that don't use a transaction end
+// the current group and are applied on their own.
+func migrateInGroups(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration, direction Direction, l Logger, o *options) (int, error) {
+	if o.continueOnError {
+		return 0, errors.New("grouping migrations in transactions cannot be combined with continuing on migration errors")
+	}
+
+	if !supportsTransactionalDDL(driver) {
+		return 0, fmt.Errorf("driver %T does not support transactional DDL, refusing to group migrations in transactions", driver)
+	}
+
+	batcher, ok := driver.(Batcher)
+	if !ok {
+		return 0, fmt.Errorf("driver %T does not support running migrations in a single transaction", driver)
+	}
+
+	count := 0
+	var group []*PlannedMigration
+	groupStatements := 0
+
+	commit := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+
+		logPrintf(l, "Applying %d migrations (%s) in a single transaction...", len(group), direction.String())
+
+		if err := batcher.MigrateBatch(ctx, group); err != nil {
+			return fmt.Errorf("Error while running migrations %s to %s in a single transaction: %w", group[0].ID, group[len(group)-1].ID, err)
+		}
+
+		logPrintf(l, "Applied %d migrations (%s) in a single transaction", len(group), direction.String())
+
+		count += len(group)
+		applied := group
+		group = nil
+		groupStatements = 0
+		return verifyMigrations(ctx, driver, applied, l)
+	}
+
+	for _, plannedMigration := range migrationsToApply {
+		if statements := statementsFor(plannedMigration); statements != nil && !statements.UseTransaction {
+			if err := commit(); err != nil {
+				return count, err
+			}
+
+			logPrintf(l, "Applying migration (%s) named '%s'...", direction.String(), plannedMigration.ID)
+
+			if err := driver.Migrate(ctx, plannedMigration); err != nil {
+				return count, &MigrationError{
+					ID:        plannedMigration.ID,
+					Direction: plannedMigration.Direction,
+					Err:       err,
+				}
+			}
+
+			logPrintf(l, "Applied migration (%s) named '%s'", direction.String(), plannedMigration.ID)
+			count++
+
+			if err := verifyMigrations(ctx, driver, []*PlannedMigration{plannedMigration}, l); err != nil {
+				return count, err
+			}
+			continue
+		}
+
+		statements := countStatements(statementsFor(plannedMigration))
+
+		if o.batchStatements > 0 && groupStatements+statements > o.batchStatements {
+			if err := commit(); err != nil {
+				return count, err
+			}
+		}
+
+		group = append(group, plannedMigration)
+		groupStatements += statements
+
+		if len(group) == o.commitEvery || (o.batchStatements > 0 && groupStatements >= o.batchStatements) {
+			if err := commit(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, commit()
+}
+
+// countStatements returns the number of SQL statements in parsed. A
+// transactional migration is usually parsed as a single block, so each block
+// is split into the statements it contains.
+func countStatements(parsed *parser.ParsedMigration) int {
+	if parsed == nil {
+		return 0
+	}
+
+	count := 0
+	for _, statement := range parsed.Statements {
+		count += len(parser.SplitStatements(statement))
+	}
+	return count
+}
+
+// statementsFor returns the statements to run for the planned direction,
+// which may be nil.
+func statementsFor(plannedMigration *PlannedMigration) *parser.ParsedMigration {
+	if plannedMigration.Direction == Down {
+		return plannedMigration.Down
+	}
+	return plannedMigration.Up
+}
+
+// MigrateIDs applies exactly the migrations listed in ids in the given
+// direction, bypassing the usual planning of pending migrations. Up migrations
+// are applied in canonical order and down migrations in reverse canonical
+// order, regardless of the order of ids. It is intended for targeted hotfixes.
+//
+// MigrateIDs is UNSAFE: skipping migrations can leave the schema in a state no
+// regular run would produce, and later runs will fill the skipped migrations
+// in as gaps. An error is returned before anything is applied if an ID is
+// unknown, or if it is already applied (for Up) or not applied (for Down).
+//
+// Like Migrate, the driver is locked if it implements Locker, and closed once
+// the migrations have been applied. Migrating down is refused in the
+// production environment, as set by WithEnvironment.
+func MigrateIDs(ctx context.Context, driver Driver, migrations Source, ids []string, direction Direction, opts ...Option) error {
+	o := newOptions(opts)
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return err
+	}
+
+	locker, ok := driver.(Locker)
+	if ok {
+		if err = locker.Lock(ctx); err != nil {
+			return err
+		}
+	}
+
+	err = migrateIDs(ctx, driver, m, ids, direction, o)
+
+	if ok {
+		if errUnlock := locker.Unlock(context.Background()); errUnlock != nil && err == nil {
+			err = errUnlock
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return driver.Close(context.Background())
+}
+
+func migrateIDs(ctx context.Context, driver Driver, m []*Migration, ids []string, direction Direction, o *options) error {
+	if direction != Up && direction != Down {
+		return &InvalidDirectionError{Direction: direction}
+	}
+
+	if direction == Down {
+		if err := checkDownAllowed(o); err != nil {
+			return err
+		}
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return err
+	}
+
+	applied := map[string]bool{}
+	for _, id := range appliedMigrations {
+		applied[id] = true
+	}
+
+	loaded := map[string]*Migration{}
+	for _, migration := range m {
+		loaded[migration.ID] = migration
+	}
+
+	var selected []*Migration
+	seen := map[string]bool{}
+	for _, id := range ids {
+		migration, ok := loaded[id]
+		if !ok {
+			return fmt.Errorf("unknown migration %s", id)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if direction == Up && applied[id] {
+			return fmt.Errorf("migration %s has already been applied", id)
+		}
+		if direction == Down && !applied[id] {
+			return fmt.Errorf("migration %s has not been applied", id)
+		}
+		selected = append(selected, migration)
+	}
+
+	if direction == Down {
+		sort.Sort(sort.Reverse(byID(selected)))
+	} else {
+		sort.Sort(byID(selected))
+	}
+
+	planned := make([]*PlannedMigration, 0, len(selected))
+	for _, migration := range selected {
+		planned = append(planned, &PlannedMigration{
+			Migration: migration,
+			Direction: direction,
+		})
+	}
+
+	return applyPlanned(ctx, driver, planned)
+}
+
+// LoadMigrations reads and parses the migrations in migrations, applying any
+// options that affect loading, such as WithTemplate or WithAutoDown. The
+// migrations are returned in the order they would be applied.
+func LoadMigrations(migrations Source, opts ...Option) ([]*Migration, error) {
+	return getMigrations(migrations, newOptions(opts))
+}
+
+// ResolveID returns the ID of the migration identified by prefix, so that
+// users can refer to migrations without typing out their full timestamped ID.
+// An exact match wins; otherwise prefix must match the start of exactly one
+// ID or, failing that, be contained in exactly one ID. If several IDs match,
+// an AmbiguousIDError listing them is returned. Migrations are loaded with
+// opts, as Migrate would load them.
+func ResolveID(migrations Source, prefix string, opts ...Option) (string, error) {
+	m, err := getMigrations(migrations, newOptions(opts))
+	if err != nil {
+		return "", err
+	}
+
+	return resolveID(m, prefix)
+}
+
+func resolveID(m []*Migration, prefix string) (string, error) {
+	if prefix == "" {
+		return "", errors.New("empty migration ID")
+	}
+
+	var prefixed, containing []string
+	for _, migration := range m {
+		switch {
+		case migration.ID == prefix:
+			return migration.ID, nil
+		case strings.HasPrefix(migration.ID, prefix):
+			prefixed = append(prefixed, migration.ID)
+		case strings.Contains(migration.ID, prefix):
+			containing = append(containing, migration.ID)
+		}
+	}
+
+	matches := prefixed
+	if len(matches) == 0 {
+		matches = containing
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown migration %s", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", &AmbiguousIDError{Prefix: prefix, IDs: matches}
+	}
+}
+
+// EnsureAt migrates up or down as needed so that exactly the migrations up to
+// and including targetID, in canonical order, are applied. targetID is
+// resolved using ResolveID, so it may be a unique part of the ID. It does nothing if
+// the database is already there, so it is safe to call on every boot.
+//
+// Migrations after targetID are rolled back first, in the reverse of the order
+// they were applied, followed by applying any migrations up to targetID that
+// are missing. Like Migrate, the driver is locked if it implements Locker, and
+// closed once the migrations have been applied. In the production environment,
+// as set by WithEnvironment, nothing is applied if a migration would have to
+// be rolled back.
+func EnsureAt(ctx context.Context, driver Driver, migrations Source, targetID string, opts ...Option) error {
+	o := newOptions(opts)
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return err
+	}
+
+	if targetID, err = resolveID(m, targetID); err != nil {
+		return err
+	}
+
+	locker, ok := driver.(Locker)
+	if ok {
+		if err = locker.Lock(ctx); err != nil {
+			return err
+		}
+	}
+
+	err = ensureAt(ctx, driver, m, targetID, o)
+
+	if ok {
+		if errUnlock := locker.Unlock(context.Background()); errUnlock != nil && err == nil {
+			err = errUnlock
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return driver.Close(context.Background())
+}
+
+func ensureAt(ctx context.Context, driver Driver, m []*Migration, targetID string, o *options) error {
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return err
+	}
+
+	applied := map[string]bool{}
+	for _, id := range appliedMigrations {
+		applied[id] = true
+	}
+
+	var planned []*PlannedMigration
+
+	for _, migration := range toRollback(m, appliedMigrations) {
+		if CompareIDs(migration.ID, targetID) > 0 {
+			planned = append(planned, &PlannedMigration{Migration: migration, Direction: Down})
+		}
+	}
+
+	if len(planned) > 0 {
+		if err := checkDownAllowed(o); err != nil {
+			return err
+		}
+	}
 
-	m, err := getMigrations(migrations)
-	if err != nil {
-		return count, err
+	if planned, err = handleMissingDown(m, planned, nil, o); err != nil {
+		return err
 	}
 
-	appliedMigrations, err := driver.Versions(ctx)
-	if err != nil {
-		return count, err
+	for _, migration := range m {
+		if CompareIDs(migration.ID, targetID) <= 0 && !applied[migration.ID] {
+			planned = append(planned, &PlannedMigration{Migration: migration, Direction: Up})
+		}
 	}
 
-	migrationsToApply := planMigrations(m, appliedMigrations, direction, max)
-	for _, plannedMigration := range migrationsToApply {
-		logPrintf(l, "Applying migration (%s) named '%s'...", direction.String(), plannedMigration.ID)
+	return applyPlanned(ctx, driver, planned)
+}
 
-		err = driver.Migrate(ctx, plannedMigration)
-		if err != nil {
-			errorMessage := "Error while running migration " + plannedMigration.ID
+// checkDownAllowed returns a DownNotAllowedError if migrating down is refused
+// in the environment set by WithEnvironment.
+func checkDownAllowed(o *options) error {
+	if strings.EqualFold(o.environment, productionEnvironment) && !o.allowProdDown {
+		return &DownNotAllowedError{Environment: o.environment}
+	}
+	return nil
+}
 
-			if plannedMigration.Direction == Up {
-				errorMessage += " (up)"
-			} else {
-				errorMessage += " (down)"
+// applyPlanned applies migrations in order, stopping at the first failure.
+func applyPlanned(ctx context.Context, driver Driver, planned []*PlannedMigration) error {
+	for _, plannedMigration := range planned {
+		if err := driver.Migrate(ctx, plannedMigration); err != nil {
+			return &MigrationError{
+				ID:        plannedMigration.ID,
+				Direction: plannedMigration.Direction,
+				Err:       err,
 			}
-			return count, fmt.Errorf(errorMessage+": %s", err)
 		}
-
-		logPrintf(l, "Applied migration (%s) named '%s'", direction.String(), plannedMigration.ID)
-		count++
 	}
 
-	err = driver.Close(context.Background())
-	return count, err
+	return nil
 }
 
 func logPrintf(l Logger, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
 	l.Printf(format, args...)
 }
 
-func getMigrations(migrations Source) ([]*Migration, error) {
+// migrationPart is one file of a migration that is split across several files,
+// such as 5_big.part1.up.sql and 5_big.part2.up.sql.
+type migrationPart struct {
+	number int
+	parsed *parser.ParsedMigration
+	source *parser.ParsedMigration
+}
+
+var partSuffixRegex = regexp.MustCompile(`^(.*)\.part(\d+)$`)
+
+func getMigrations(migrations Source, o *options) ([]*Migration, error) {
 	var m []*Migration
 	tempMigrations := map[string]*Migration{}
+	parts := map[string]map[string][]migrationPart{}
 
 	files, err := migrations.ListMigrationFiles()
 	if err != nil {
 		return m, err
 	}
 
-	regex := regexp.MustCompile(`(\d*_.*)\.(up|down)\..*`)
+	regex := regexp.MustCompile(`(\d*_.*)\.(up|down|guard|post|verify)\..*`)
 
 	for _, file := range files {
 		matches := regex.FindStringSubmatch(file)
@@ -156,46 +1173,314 @@ func getMigrations(migrations Source) ([]*Migration, error) {
 		if len(matches) > 0 && file == matches[0] {
 			id := matches[1]
 			direction := matches[2]
+			part := 0
+
+			if partMatches := partSuffixRegex.FindStringSubmatch(id); len(partMatches) > 0 {
+				id = partMatches[1]
+				part, err = strconv.Atoi(partMatches[2])
+				if err != nil {
+					return m, fmt.Errorf("Error parsing part number of migration file %s: %s", file, err)
+				}
+			}
 
 			if _, ok := tempMigrations[id]; !ok {
 				tempMigrations[id] = &Migration{
 					ID: id,
 				}
+				parts[id] = map[string][]migrationPart{}
 			}
 
-			reader, err := migrations.GetMigrationFile(file)
+			parsed, source, err := parseMigrationFile(migrations, file, id, o)
 			if err != nil {
-				return m, fmt.Errorf("Error getting migrations: %s", err)
+				return m, err
 			}
 
-			contents, err := ioutil.ReadAll(reader)
-			if err != nil {
-				return m, fmt.Errorf("Error getting migration content: %s", err)
-			}
+			parts[id][direction] = append(parts[id][direction], migrationPart{number: part, parsed: parsed, source: source})
+		}
+	}
 
-			parsed, err := parser.Parse(bytes.NewReader(contents))
-			if err != nil {
-				return m, fmt.Errorf("Error parsing migration %s: %s", id, err)
+	for id, migration := range tempMigrations {
+		migration.Up = combineParts(parts[id]["up"])
+		migration.Down = combineParts(parts[id]["down"])
+		migration.sourceUp = combineSources(parts[id]["up"])
+		migration.sourceDown = combineSources(parts[id]["down"])
+		migration.sourced = true
+		migration.Guard = combineParts(parts[id]["guard"])
+		migration.PostHook = splitBlocks(combineParts(parts[id]["post"]))
+		migration.Verify = splitBlocks(combineParts(parts[id]["verify"]))
+		if o.autoDown && isEmpty(migration.Down) && !isEmpty(migration.Up) {
+			if migration.Down, err = AutoDown(migration.Up); err != nil {
+				return m, fmt.Errorf("Error generating down migration for %s: %s", id, err)
 			}
+		}
+		migration.Description = description(migration)
+		migration.Requires = requires(migration)
+		migration.Tags = tags(migration)
+		m = append(m, migration)
+	}
+
+	sort.Sort(byID(m))
 
-			if direction == "up" {
-				tempMigrations[id].Up = parsed
-			} else {
-				tempMigrations[id].Down = parsed
+	if o.strictLoad {
+		for _, migration := range m {
+			if migration.Up != nil && migration.Down == nil && !migration.Up.Irreversible {
+				return m, &MissingDownError{ID: migration.ID}
 			}
 		}
 	}
 
-	for _, migration := range tempMigrations {
-		m = append(m, migration)
+	if m, err = sortByRequires(m); err != nil {
+		return m, err
 	}
 
-	sort.Sort(byID(m))
+	if o.requireMigrations && len(m) == 0 {
+		return m, &NoMigrationsError{}
+	}
 
 	return m, nil
 }
 
-func planMigrations(migrations []*Migration, appliedMigrations []string, direction Direction, max int) []*PlannedMigration {
+// parseMigrationFile reads and parses file, a file of migration id, running it
+// through text/template first if templating is enabled. It also returns the
+// file as parsed before templating, which is the same migration unless
+// templating is enabled.
+func parseMigrationFile(migrations Source, file, id string, o *options) (*parser.ParsedMigration, *parser.ParsedMigration, error) {
+	reader, err := migrations.GetMigrationFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error getting migrations: %s", err)
+	}
+
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error getting migration content: %s", err)
+	}
+
+	contents := raw
+	if o.templating {
+		contents, err = executeTemplate(file, contents, o.templateData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error executing template for migration %s: %s", id, err)
+		}
+	}
+
+	parsed, err := parser.Parse(bytes.NewReader(contents))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing migration %s: %s", id, err)
+	}
+
+	if !o.templating {
+		return parsed, parsed, nil
+	}
+
+	// Template actions may not parse as a migration, in which case the file
+	// is kept as a single statement.
+	source, err := parser.Parse(bytes.NewReader(raw))
+	if err != nil {
+		source = &parser.ParsedMigration{UseTransaction: true, Statements: []string{string(raw)}}
+	}
+
+	return parsed, source, nil
+}
+
+// executeTemplate runs contents through text/template with data. Referencing
+// a missing map key is an error, like referencing a missing struct field.
+func executeTemplate(name string, contents []byte, data interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// combineParts joins the parts of a migration into one, preserving statement
+// order across parts. The combined migration only uses a transaction if every
+// part does.
+// combineSources combines the parts of a migration as parsed before
+// templating, as combineParts does.
+func combineSources(parts []migrationPart) *parser.ParsedMigration {
+	sources := make([]migrationPart, 0, len(parts))
+	for _, part := range parts {
+		sources = append(sources, migrationPart{number: part.number, parsed: part.source})
+	}
+	return combineParts(sources)
+}
+
+func combineParts(parts []migrationPart) *parser.ParsedMigration {
+	switch len(parts) {
+	case 0:
+		return nil
+	case 1:
+		return parts[0].parsed
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].number < parts[j].number
+	})
+
+	combined := &parser.ParsedMigration{
+		UseTransaction: true,
+		Statements:     []string{},
+	}
+
+	for _, part := range parts {
+		if combined.Description == "" {
+			combined.Description = part.parsed.Description
+		}
+		combined.UseTransaction = combined.UseTransaction && part.parsed.UseTransaction
+		combined.Irreversible = combined.Irreversible || part.parsed.Irreversible
+		if compareVersions(part.parsed.MinServerVersion, combined.MinServerVersion) > 0 {
+			combined.MinServerVersion = part.parsed.MinServerVersion
+		}
+		combined.Tags = append(combined.Tags, part.parsed.Tags...)
+		combined.Statements = append(combined.Statements, part.parsed.Statements...)
+	}
+
+	return combined
+}
+
+// splitBlocks splits the statements of parsed into individual statements, as
+// post-hook statements such as VACUUM must be run on their own, and each
+// verification query is evaluated on its own.
+func splitBlocks(parsed *parser.ParsedMigration) []string {
+	if parsed == nil {
+		return nil
+	}
+
+	var statements []string
+	for _, block := range parsed.Statements {
+		for _, statement := range parser.SplitStatements(block) {
+			if strings.TrimSpace(statement) != "" {
+				statements = append(statements, statement)
+			}
+		}
+	}
+	return statements
+}
+
+// isEmpty reports whether parsed is missing or contains only whitespace.
+func isEmpty(parsed *parser.ParsedMigration) bool {
+	if parsed == nil {
+		return true
+	}
+	for _, statement := range parsed.Statements {
+		if strings.TrimSpace(statement) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func description(migration *Migration) string {
+	for _, parsed := range []*parser.ParsedMigration{migration.Up, migration.Down} {
+		if parsed != nil && parsed.Description != "" {
+			return parsed.Description
+		}
+	}
+	return ""
+}
+
+func requires(migration *Migration) []string {
+	for _, parsed := range []*parser.ParsedMigration{migration.Up, migration.Down} {
+		if parsed != nil && len(parsed.Requires) > 0 {
+			return parsed.Requires
+		}
+	}
+	return nil
+}
+
+func tags(migration *Migration) []string {
+	for _, parsed := range []*parser.ParsedMigration{migration.Up, migration.Down} {
+		if parsed != nil && len(parsed.Tags) > 0 {
+			return parsed.Tags
+		}
+	}
+	return nil
+}
+
+// hasRequires reports whether any migration declares requirements.
+func hasRequires(migrations []*Migration) bool {
+	for _, migration := range migrations {
+		if len(migration.Requires) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByRequires orders migrations, which must be sorted by ID, so that every
+// migration follows the migrations it requires. Among migrations whose
+// requirements are met, the one with the lowest ID comes first, so the order
+// only departs from ID order where requirements demand it.
+func sortByRequires(migrations []*Migration) ([]*Migration, error) {
+	if !hasRequires(migrations) {
+		return migrations, nil
+	}
+
+	index := map[string]int{}
+	for i, migration := range migrations {
+		index[migration.ID] = i
+	}
+
+	pending := make([]int, len(migrations))
+	dependents := make([][]int, len(migrations))
+
+	for i, migration := range migrations {
+		for _, id := range migration.Requires {
+			j, ok := index[id]
+			if !ok {
+				return migrations, &MissingDependencyError{ID: migration.ID, Requires: id}
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range migrations {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	sorted := make([]*Migration, 0, len(migrations))
+
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		next := ready[0]
+		ready = ready[1:]
+
+		sorted = append(sorted, migrations[next])
+
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(sorted) < len(migrations) {
+		var cycle []string
+		for i, migration := range migrations {
+			if pending[i] > 0 {
+				cycle = append(cycle, migration.ID)
+			}
+		}
+		return migrations, &DependencyCycleError{IDs: cycle}
+	}
+
+	return sorted, nil
+}
+
+// toMigrations converts applied versions into migrations sorted in canonical
+// order.
+func toMigrations(appliedMigrations []string) []*Migration {
 	var applied []*Migration
 
 	for _, appliedMigration := range appliedMigrations {
@@ -206,6 +1491,16 @@ func planMigrations(migrations []*Migration, appliedMigrations []string, directi
 
 	sort.Sort(byID(applied))
 
+	return applied
+}
+
+func planMigrations(migrations []*Migration, appliedMigrations []string, direction Direction, max int) []*PlannedMigration {
+	if hasRequires(migrations) {
+		return planWithRequires(migrations, appliedMigrations, direction, max)
+	}
+
+	applied := toMigrations(appliedMigrations)
+
 	// Get last migration that was run
 	record := &Migration{}
 
@@ -221,8 +1516,18 @@ func planMigrations(migrations []*Migration, appliedMigrations []string, directi
 		result = append(result, toCatchup(migrations, applied, record)...)
 	}
 
-	// Figure out which migrations to apply
-	toApply := toApply(migrations, record.ID, direction)
+	// Figure out which migrations to apply. Rollbacks undo migrations in the
+	// reverse of the order they were applied, including any caught up above.
+	var toApply []*Migration
+	if direction == Down {
+		appliedInOrder := append([]string{}, appliedMigrations...)
+		for _, caughtUp := range result {
+			appliedInOrder = append(appliedInOrder, caughtUp.ID)
+		}
+		toApply = toRollback(migrations, appliedInOrder)
+	} else {
+		toApply = toApplyUp(migrations, record.ID)
+	}
 	toApplyCount := len(toApply)
 
 	if max > 0 && max < toApplyCount {
@@ -239,8 +1544,45 @@ func planMigrations(migrations []*Migration, appliedMigrations []string, directi
 	return result
 }
 
+// planWithRequires plans migrations ordered by their requirements. Going up,
+// every unapplied migration is planned in that order, so there is no separate
+// catch up of migrations skipped by merges. Going down, migrations are rolled
+// back in the reverse of the order they were applied.
+func planWithRequires(migrations []*Migration, appliedMigrations []string, direction Direction, max int) []*PlannedMigration {
+	var toApply []*Migration
+
+	if direction == Down {
+		toApply = toRollback(migrations, appliedMigrations)
+	} else {
+		applied := map[string]bool{}
+		for _, id := range appliedMigrations {
+			applied[id] = true
+		}
+
+		for _, migration := range migrations {
+			if !applied[migration.ID] {
+				toApply = append(toApply, migration)
+			}
+		}
+	}
+
+	if max > 0 && max < len(toApply) {
+		toApply = toApply[:max]
+	}
+
+	var result []*PlannedMigration
+	for _, migration := range toApply {
+		result = append(result, &PlannedMigration{
+			Migration: migration,
+			Direction: direction,
+		})
+	}
+
+	return result
+}
+
 // Filter a slice of migrations into ones that should be applied.
-func toApply(migrations []*Migration, current string, direction Direction) []*Migration {
+func toApplyUp(migrations []*Migration, current string) []*Migration {
 	var index = -1
 
 	if current != "" {
@@ -252,22 +1594,29 @@ func toApply(migrations []*Migration, current string, direction Direction) []*Mi
 		}
 	}
 
-	if direction == Up {
-		return migrations[index+1:]
-	} else if direction == Down {
-		if index == -1 {
-			return []*Migration{}
-		}
+	return migrations[index+1:]
+}
 
-		// Add in reverse order
-		toApply := make([]*Migration, index+1)
-		for i := 0; i < index+1; i++ {
-			toApply[index-i] = migrations[i]
+// Filter a slice of migrations into ones that should be rolled back, in the
+// reverse of the order they were applied. Applied migrations that are not in
+// migrations are included with only their ID set, so that callers can decide
+// how to handle them.
+func toRollback(migrations []*Migration, appliedInOrder []string) []*Migration {
+	loaded := map[string]*Migration{}
+	for _, migration := range migrations {
+		loaded[migration.ID] = migration
+	}
+
+	var rollback []*Migration
+	for i := len(appliedInOrder) - 1; i >= 0; i-- {
+		migration, ok := loaded[appliedInOrder[i]]
+		if !ok {
+			migration = &Migration{ID: appliedInOrder[i]}
 		}
-		return toApply
+		rollback = append(rollback, migration)
 	}
 
-	panic("Not possible")
+	return rollback
 }
 
 // Get migrations that we need to apply regardless of whether the direction is up or down. This is
@@ -292,3 +1641,195 @@ func toCatchup(migrations, existingMigrations []*Migration, lastRun *Migration)
 
 	return missing
 }
+
+// Gaps returns the IDs of migrations that have not been applied, yet precede
+// the last applied migration in canonical order. This usually indicates
+// migrations added by a merge, or manual changes to the version table.
+// Migrations are loaded with opts, as Migrate would load them.
+func Gaps(ctx context.Context, driver Driver, migrations Source, opts ...Option) ([]string, error) {
+	m, err := getMigrations(migrations, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return gaps(m, appliedMigrations), nil
+}
+
+func gaps(migrations []*Migration, appliedMigrations []string) []string {
+	var ids []string
+
+	applied := toMigrations(appliedMigrations)
+	if len(applied) == 0 {
+		return ids
+	}
+
+	for _, missing := range toCatchup(migrations, applied, applied[len(applied)-1]) {
+		ids = append(ids, missing.ID)
+	}
+
+	return ids
+}
+
+// MigrationStatus describes whether a migration has been applied.
+type MigrationStatus struct {
+	ID          string
+	Description string
+	Applied     bool
+}
+
+// Status returns the status of each migration in canonical order. Migrations
+// are loaded with opts, as Migrate would load them.
+func Status(ctx context.Context, driver Driver, migrations Source, opts ...Option) ([]MigrationStatus, error) {
+	m, err := getMigrations(migrations, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[string]bool{}
+	for _, id := range appliedMigrations {
+		applied[id] = true
+	}
+
+	status := make([]MigrationStatus, 0, len(m))
+	for _, migration := range m {
+		status = append(status, MigrationStatus{
+			ID:          migration.ID,
+			Description: migration.Description,
+			Applied:     applied[migration.ID],
+		})
+	}
+
+	return status, nil
+}
+
+// PendingCount returns the number of migrations that Migrate would apply in
+// the Up direction, including gaps before the last applied migration. It is a
+// cheap check for gating startup, for example to log how many migrations are
+// pending and leave applying them to a dedicated job. Migrations are loaded
+// and planned with opts, as Migrate would, so that with WithStrictOrder, gaps
+// are reported as a GapError.
+func PendingCount(ctx context.Context, driver Driver, migrations Source, opts ...Option) (int, error) {
+	o := newOptions(opts)
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return 0, err
+	}
+
+	planned, err := plan(ctx, driver, m, Up, 0, nil, o)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(planned), nil
+}
+
+// SchemaVersion returns a deterministic fingerprint of the applied migrations,
+// suitable for stamping build artifacts. It consists of the latest applied ID
+// in canonical order followed by a short hash of all applied IDs, for example
+// "3_add_column-9f86d081884c7d65". Databases with identical histories produce
+// identical fingerprints, regardless of the order the driver reports them in.
+// If no migrations have been applied, the latest ID is reported as "none".
+func SchemaVersion(ctx context.Context, driver Driver) (string, error) {
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	applied := toMigrations(appliedMigrations)
+
+	latest := "none"
+	ids := make([]string, 0, len(applied))
+	for _, migration := range applied {
+		ids = append(ids, migration.ID)
+		latest = migration.ID
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+
+	return fmt.Sprintf("%s-%x", latest, sum[:8]), nil
+}
+
+// Seed executes statements against the driver without recording them as a
+// migration. It is intended for idempotent seed data that is (re)applied on
+// every startup after migrating, so the statements must be safe to run more
+// than once. The statements are run within a single transaction.
+//
+// The driver must implement Execer.
+func Seed(ctx context.Context, driver Driver, statements []string) error {
+	execer, ok := driver.(Execer)
+	if !ok {
+		return fmt.Errorf("driver %T does not support seeding", driver)
+	}
+
+	err := execer.Exec(ctx, &parser.ParsedMigration{
+		UseTransaction: true,
+		Statements:     statements,
+	})
+	if err != nil {
+		return fmt.Errorf("Error while seeding: %s", err)
+	}
+
+	return nil
+}
+
+// ReapplyHead re-runs the up statements of the most recently applied
+// migration, as reported by the driver's Versions, without touching the
+// version table. Unlike rolling the migration back and applying it again, its
+// down migration is not run, so the up migration must be safe to run more
+// than once, for example by using IF NOT EXISTS or CREATE OR REPLACE.
+//
+// The driver must implement Execer.
+func ReapplyHead(ctx context.Context, driver Driver, migrations Source, opts ...Option) error {
+	o := newOptions(opts)
+
+	execer, ok := driver.(Execer)
+	if !ok {
+		return fmt.Errorf("driver %T does not support reapplying migrations", driver)
+	}
+
+	m, err := getMigrations(migrations, o)
+	if err != nil {
+		return err
+	}
+
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(appliedMigrations) == 0 {
+		return errors.New("no migrations have been applied")
+	}
+
+	head := appliedMigrations[len(appliedMigrations)-1]
+
+	var migration *Migration
+	for _, candidate := range m {
+		if candidate.ID == head {
+			migration = candidate
+			break
+		}
+	}
+	if migration == nil {
+		return fmt.Errorf("the latest applied migration %s is not in the migration set", head)
+	}
+	if isEmpty(migration.Up) {
+		return nil
+	}
+
+	if err := execer.Exec(ctx, migration.Up); err != nil {
+		return &MigrationError{ID: migration.ID, Direction: Up, Err: err}
+	}
+
+	return nil
+}
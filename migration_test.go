@@ -2,9 +2,13 @@ package migration
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -285,3 +289,1669 @@ func TestMigrationWithError(t *testing.T) {
 		t.Errorf("No migrations should be applied, but %d was applied.", applied2)
 	}
 }
+
+func TestSeed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":   "CREATE TABLE test_table (id integer not null primary key)",
+			"1_init.down.sql": "DROP TABLE test_table",
+		},
+	}
+
+	driver := getMockDriver()
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger); err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+
+	seeds := []string{
+		"INSERT INTO test_table (id) VALUES (1) ON CONFLICT DO NOTHING",
+		"INSERT INTO test_table (id) VALUES (2) ON CONFLICT DO NOTHING",
+	}
+
+	if err := Seed(ctx, driver, seeds); err != nil {
+		t.Errorf("Unexpected error while seeding: %s", err)
+	}
+	if !reflect.DeepEqual(driver.executed, seeds) {
+		t.Errorf("Expected seed statements %v to be executed, got %v", seeds, driver.executed)
+	}
+
+	versions, err := driver.Versions(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting versions: %s", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"1_init"}) {
+		t.Errorf("Expected seeding to leave versions unchanged, got %v", versions)
+	}
+
+	if err := Seed(ctx, driver, []string{"error"}); err == nil {
+		t.Error("Expected error while seeding, but there was no error")
+	}
+}
+
+func TestReapplyHead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":   "CREATE TABLE test_table (id integer not null primary key)",
+			"1_init.down.sql": "DROP TABLE test_table",
+			"2_view.up.sql":   "CREATE OR REPLACE VIEW test_view AS SELECT id FROM test_table",
+			"2_view.down.sql": "DROP VIEW test_view",
+		},
+	}
+
+	driver := getMockDriver()
+
+	if err := ReapplyHead(ctx, driver, memoryMigration); err == nil {
+		t.Error("Expected error while reapplying without applied migrations, but there was no error")
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger); err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+
+	if err := ReapplyHead(ctx, driver, memoryMigration); err != nil {
+		t.Fatalf("Unexpected error while reapplying the head migration: %s", err)
+	}
+
+	expected := []string{"CREATE OR REPLACE VIEW test_view AS SELECT id FROM test_table"}
+	if !reflect.DeepEqual(driver.executed, expected) {
+		t.Errorf("Expected the up statements of the head migration %v to be executed, got %v", expected, driver.executed)
+	}
+
+	versions, err := driver.Versions(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting versions: %s", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"1_init", "2_view"}) {
+		t.Errorf("Expected reapplying to leave versions unchanged, got %v", versions)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_column.up.sql":   "",
+			"003_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"003_column", "001_init"}
+
+	gaps, err := Gaps(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while detecting gaps: %s", err)
+	}
+	if !reflect.DeepEqual(gaps, []string{"002_update"}) {
+		t.Errorf("Expected gaps to be %v, got %v", []string{"002_update"}, gaps)
+	}
+
+	var missingDownErr *MissingDownError
+	irreversible := &MemoryMigrationSource{Files: map[string]string{"001_init.up.sql": ""}}
+	if _, err := Gaps(ctx, driver, irreversible, WithStrictLoad()); !errors.As(err, &missingDownErr) {
+		t.Errorf("Expected a MissingDownError detecting gaps with strict loading, got %v", err)
+	}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithStrictOrder())
+	var gapErr *GapError
+	if !errors.As(err, &gapErr) {
+		t.Fatalf("Expected a GapError in strict order mode, got %v", err)
+	}
+	if !reflect.DeepEqual(gapErr.IDs, []string{"002_update"}) {
+		t.Errorf("Expected GapError to list %v, got %v", []string{"002_update"}, gapErr.IDs)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied in strict order mode, %d applied.", applied)
+	}
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Errorf("Unexpected error while performing migration: %s", err)
+	}
+	if applied != 1 {
+		t.Errorf("Expected the gap to be filled by default, %d applied.", applied)
+	}
+}
+
+func TestMigrateContinueOnMigrationError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":     "",
+			"1_init.down.sql":   "",
+			"2_update.up.sql":   "error",
+			"2_update.down.sql": "",
+			"3_column.up.sql":   "",
+			"3_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithContinueOnMigrationError())
+
+	var failed MigrationErrors
+	if !errors.As(err, &failed) {
+		t.Fatalf("Expected MigrationErrors, got %v", err)
+	}
+	if len(failed) != 1 || failed[0].ID != "2_update" {
+		t.Errorf("Expected the aggregate error to list 2_update, got %s", failed)
+	}
+	if applied != 2 {
+		t.Errorf("Expected %d migrations to be applied, %d applied.", 2, applied)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"1_init", "3_column"}) {
+		t.Errorf("Expected migrations after the failure to be applied, got %v", driver.applied)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	driver1 := getMockDriver()
+	driver1.applied = []string{"1_init", "2_update", "10_column"}
+
+	driver2 := getMockDriver()
+	driver2.applied = []string{"10_column", "1_init", "2_update"}
+
+	driver3 := getMockDriver()
+	driver3.applied = []string{"1_init", "10_column"}
+
+	version1, err := SchemaVersion(ctx, driver1)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting schema version: %s", err)
+	}
+	version2, err := SchemaVersion(ctx, driver2)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting schema version: %s", err)
+	}
+	version3, err := SchemaVersion(ctx, driver3)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting schema version: %s", err)
+	}
+
+	if version1 != version2 {
+		t.Errorf("Expected identical histories to produce identical versions, got %q and %q", version1, version2)
+	}
+	if version1 == version3 {
+		t.Errorf("Expected differing histories to produce differing versions, got %q for both", version1)
+	}
+	if !strings.HasPrefix(version1, "10_column-") {
+		t.Errorf("Expected schema version to start with the latest applied ID, got %q", version1)
+	}
+}
+
+func TestMigrateLocking(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":     "",
+			"1_init.down.sql":   "",
+			"2_update.up.sql":   "",
+			"2_update.down.sql": "",
+			"3_column.up.sql":   "",
+			"3_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	var wg sync.WaitGroup
+	counts := make([]int, 2)
+	errs := make([]error, 2)
+
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i], errs[i] = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error while performing migration: %s", err)
+		}
+	}
+	if counts[0]+counts[1] != 3 {
+		t.Errorf("Expected %d migrations to be applied in total, %d applied.", 3, counts[0]+counts[1])
+	}
+	if driver.overlap {
+		t.Error("Expected migration runs to be serialized by the lock")
+	}
+
+	// Hold the lock to simulate another process migrating.
+	if err := driver.Lock(ctx); err != nil {
+		t.Fatalf("Unexpected error while acquiring lock: %s", err)
+	}
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer timeoutCancel()
+
+	_, err := Migrate(timeoutCtx, driver, memoryMigration, Down, 1, testLogger)
+	var lockErr *LockTimeoutError
+	if !errors.As(err, &lockErr) {
+		t.Errorf("Expected a LockTimeoutError while the lock is held, got %v", err)
+	}
+}
+
+func TestMigrationWithParts(t *testing.T) {
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":          "CREATE TABLE a (id integer);",
+			"1_init.down.sql":        "DROP TABLE a;",
+			"5_big.part2.up.sql":     "CREATE TABLE c (id integer);",
+			"5_big.part1.up.sql":     "CREATE TABLE b (id integer);",
+			"5_big.part10.up.sql":    "-- +migration NoTransaction\nCREATE TABLE d (id integer);",
+			"5_big.down.sql":         "DROP TABLE d;\nDROP TABLE c;\nDROP TABLE b;",
+			"6_single.part1.up.sql":  "CREATE TABLE e (id integer);",
+			"6_single.down.sql":      "DROP TABLE e;",
+			"7_other.part1.down.sql": "DROP TABLE f;",
+		},
+	}
+
+	migrations, err := getMigrations(memoryMigration, newOptions(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while getting migrations: %s", err)
+	}
+
+	ids := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		ids = append(ids, migration.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"1_init", "5_big", "6_single", "7_other"}) {
+		t.Fatalf("Expected parts to be combined into one migration each, got %v", ids)
+	}
+
+	big := migrations[1]
+	expected := []string{
+		"CREATE TABLE b (id integer);",
+		"CREATE TABLE c (id integer);",
+		"CREATE TABLE d (id integer);",
+	}
+	if !reflect.DeepEqual(big.Up.Statements, expected) {
+		t.Errorf("Expected statements to be ordered by part, got %q", big.Up.Statements)
+	}
+	if big.Up.UseTransaction {
+		t.Error("Expected the combined migration to not use a transaction, because one of its parts disables it")
+	}
+	if big.Down == nil || len(big.Down.Statements) != 1 {
+		t.Errorf("Expected the down migration of a multi-part migration to be loaded, got %v", big.Down)
+	}
+
+	if !migrations[2].Up.UseTransaction {
+		t.Error("Expected a single part migration to keep its transaction setting")
+	}
+}
+
+func TestMigrateDownInReverseApplicationOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_init.up.sql":     "",
+			"1_init.down.sql":   "",
+			"2_update.up.sql":   "",
+			"2_update.down.sql": "",
+			"3_column.up.sql":   "",
+			"3_column.down.sql": "",
+		},
+	}
+
+	migrations, err := getMigrations(memoryMigration, newOptions(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while getting migrations: %s", err)
+	}
+
+	// 2_update was applied out of order, after 3_column.
+	appliedInOrder := []string{"1_init", "3_column", "2_update"}
+
+	var planned []string
+	for _, migration := range planMigrations(migrations, appliedInOrder, Down, 0) {
+		if migration.Direction != Down {
+			t.Errorf("Expected migration %s to be planned down, got %s", migration.ID, migration.Direction)
+		}
+		planned = append(planned, migration.ID)
+	}
+
+	expected := []string{"2_update", "3_column", "1_init"}
+	if !reflect.DeepEqual(planned, expected) {
+		t.Errorf("Expected rollback order %v, got %v", expected, planned)
+	}
+
+	driver := getMockDriver()
+	driver.applied = appliedInOrder
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Down, 1, testLogger)
+	if err != nil {
+		t.Errorf("Unexpected error while performing migration: %s", err)
+	}
+	if applied != 1 {
+		t.Errorf("Expected %d migrations to be applied, %d applied.", 1, applied)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"1_init", "3_column"}) {
+		t.Errorf("Expected the most recently applied migration to be rolled back, got %v", driver.applied)
+	}
+}
+
+func TestMigrateIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_column.up.sql":   "",
+			"003_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	err := MigrateIDs(ctx, driver, memoryMigration, []string{"003_column", "001_init"}, Up)
+	if err != nil {
+		t.Fatalf("Unexpected error while applying a subset of migrations: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "003_column"}) {
+		t.Errorf("Expected applied migrations to be %v, got %v", []string{"001_init", "003_column"}, driver.applied)
+	}
+
+	err = MigrateIDs(ctx, driver, memoryMigration, []string{"001_init"}, Up)
+	if err == nil {
+		t.Error("Expected an error when applying a migration that has already been applied")
+	}
+
+	err = MigrateIDs(ctx, driver, memoryMigration, []string{"002_update", "004_unknown"}, Up)
+	if err == nil || !strings.Contains(err.Error(), "004_unknown") {
+		t.Errorf("Expected an error mentioning the unknown migration, got %v", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "003_column"}) {
+		t.Errorf("Expected no migrations to be applied when an ID is unknown, got %v", driver.applied)
+	}
+
+	err = MigrateIDs(ctx, driver, memoryMigration, []string{"001_init"}, Down)
+	if err != nil {
+		t.Fatalf("Unexpected error while rolling back a subset of migrations: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"003_column"}) {
+		t.Errorf("Expected applied migrations to be %v, got %v", []string{"003_column"}, driver.applied)
+	}
+}
+
+func TestMigrateSingleTransaction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_error.up.sql":    "error",
+			"003_error.down.sql":  "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSingleTransaction())
+	if err == nil {
+		t.Fatal("Expected an error when using single transaction mode with a driver without transactional DDL")
+	}
+	if applied != 0 || len(driver.applied) != 0 {
+		t.Errorf("Expected no migrations to be applied, got %v", driver.applied)
+	}
+
+	driver.transactionalDDL = true
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSingleTransaction())
+	if err == nil {
+		t.Fatal("Expected an error when a migration fails in single transaction mode")
+	}
+	if applied != 0 || len(driver.applied) != 0 {
+		t.Errorf("Expected no migrations to be applied when one fails, got %v", driver.applied)
+	}
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 2, testLogger, WithSingleTransaction())
+	if err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected 2 migrations to be applied, got %d: %v", applied, driver.applied)
+	}
+}
+
+func TestMigrateWithSavepoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_error.up.sql":    "error",
+			"003_error.down.sql":  "",
+			"004_index.up.sql":    "",
+			"004_index.down.sql":  "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSavepoints()); err == nil {
+		t.Fatal("Expected an error when using savepoint mode with a driver without transactional DDL")
+	}
+
+	driver.transactionalDDL = true
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSavepoints())
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ID != "003_error" {
+		t.Fatalf("Expected the failing migration to be reported, got %v", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected the migrations before the failing one to be applied, got %d: %v", applied, driver.applied)
+	}
+
+	driver = getMockDriver()
+	driver.transactionalDDL = true
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSavepoints(), WithContinueOnMigrationError())
+	var migrationErrs MigrationErrors
+	if !errors.As(err, &migrationErrs) || len(migrationErrs) != 1 || migrationErrs[0].ID != "003_error" {
+		t.Fatalf("Expected the failing migration to be reported, got %v", err)
+	}
+	if applied != 3 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update", "004_index"}) {
+		t.Errorf("Expected every migration but the failing one to be applied, got %d: %v", applied, driver.applied)
+	}
+	if len(driver.batches) != 1 || len(driver.batches[0]) != 4 {
+		t.Errorf("Expected the migrations to be applied in a single transaction, got %v", driver.batches)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSavepoints(), WithSingleTransaction()); err == nil {
+		t.Error("Expected an error when combining savepoint mode with single transaction mode")
+	}
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithSavepoints(), WithBatchSmallMigrations(10)); err == nil || !strings.Contains(err.Error(), "WithBatchSmallMigrations") {
+		t.Errorf("Expected an error naming WithBatchSmallMigrations when combining it with savepoint mode, got %v", err)
+	}
+}
+
+func TestMigrateWithTemplate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "CREATE TABLE events PARTITION BY HASH (id) PARTITIONS {{ .Partitions }};",
+			"001_init.down.sql": "DROP TABLE events;",
+		},
+	}
+
+	data := struct{ Partitions int }{Partitions: 16}
+
+	migrations, err := getMigrations(memoryMigration, newOptions([]Option{WithTemplate(data)}))
+	if err != nil {
+		t.Fatalf("Unexpected error while loading templated migrations: %s", err)
+	}
+
+	expected := []string{"CREATE TABLE events PARTITION BY HASH (id) PARTITIONS 16;"}
+	if !reflect.DeepEqual(migrations[0].Up.Statements, expected) {
+		t.Errorf("Expected statements to be %v, got %v", expected, migrations[0].Up.Statements)
+	}
+
+	memoryMigration.Files["002_missing.up.sql"] = "SELECT {{ .Missing }};"
+
+	driver := getMockDriver()
+
+	_, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithTemplate(data))
+	if err == nil || !strings.Contains(err.Error(), "002_missing") {
+		t.Errorf("Expected a template error naming the migration, got %v", err)
+	}
+	if len(driver.applied) != 0 {
+		t.Errorf("Expected no migrations to be applied, got %v", driver.applied)
+	}
+}
+
+func TestPendingCount(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_column.up.sql":   "",
+			"003_column.down.sql": "",
+			"004_index.up.sql":    "",
+			"004_index.down.sql":  "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init", "003_column"}
+
+	pending, err := PendingCount(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while counting pending migrations: %s", err)
+	}
+	if pending != 2 {
+		t.Errorf("Expected 2 pending migrations, got %d", pending)
+	}
+
+	var gapErr *GapError
+	if _, err := PendingCount(ctx, driver, memoryMigration, WithStrictOrder()); !errors.As(err, &gapErr) {
+		t.Errorf("Expected a GapError counting pending migrations in strict order, got %v", err)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger); err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+
+	pending, err = PendingCount(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while counting pending migrations: %s", err)
+	}
+	if pending != 0 {
+		t.Errorf("Expected no pending migrations, got %d", pending)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "-- description: Create the users table\nCREATE TABLE users (id integer);",
+			"001_init.down.sql":   "DROP TABLE users;",
+			"002_update.up.sql":   "ALTER TABLE users ADD COLUMN name text;",
+			"002_update.down.sql": "-- description: Add a name to users\nALTER TABLE users DROP COLUMN name;",
+			"003_column.up.sql":   "",
+			"003_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	status, err := Status(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting status: %s", err)
+	}
+
+	expected := []MigrationStatus{
+		{ID: "001_init", Description: "Create the users table", Applied: true},
+		{ID: "002_update", Description: "Add a name to users", Applied: false},
+		{ID: "003_column", Description: "", Applied: false},
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected status to be %+v, got %+v", expected, status)
+	}
+
+	delete(memoryMigration.Files, "003_column.down.sql")
+
+	var missingDownErr *MissingDownError
+	if _, err := Status(ctx, driver, memoryMigration, WithStrictLoad()); !errors.As(err, &missingDownErr) || missingDownErr.ID != "003_column" {
+		t.Errorf("Expected a MissingDownError for 003_column with strict loading, got %v", err)
+	}
+}
+
+func TestCompareIDs(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2_b", "10_a", -1},
+		{"10_a", "2_b", 1},
+		{"1_a", "1_b", -1},
+		{"1_a", "1_a", 0},
+		{"1_a", "a_1", -1},
+		{"a_1", "1_a", 1},
+		{"a_1", "b_1", -1},
+		{"V1_Foo", "v1_foo", -1},
+		{"1_Foo", "1_foo", -1},
+		{"1_foo", "1_Foo", 1},
+	}
+
+	for _, test := range tests {
+		if actual := CompareIDs(test.a, test.b); actual != test.expected {
+			t.Errorf("Expected CompareIDs(%q, %q) to be %d, got %d", test.a, test.b, test.expected, actual)
+		}
+	}
+}
+
+func TestMigrateCaseSensitiveIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"1_Foo.up.sql":   "",
+			"1_Foo.down.sql": "",
+			"1_foo.up.sql":   "",
+			"1_foo.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"1_Foo", "1_foo"}) {
+		t.Errorf("Expected IDs differing in case to be distinct migrations, got %d: %v", applied, driver.applied)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Down, 1, testLogger); err != nil {
+		t.Fatalf("Unexpected error while rolling back: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"1_Foo"}) {
+		t.Errorf("Expected only 1_foo to be rolled back, got %v", driver.applied)
+	}
+}
+
+func TestMigrateDownWithMissingMigration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init", "002_update", "003_deleted"}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Down, 0, testLogger)
+	var missingErr *MissingMigrationError
+	if !errors.As(err, &missingErr) || missingErr.ID != "003_deleted" {
+		t.Fatalf("Expected a MissingMigrationError for 003_deleted, got %v", err)
+	}
+	if applied != 0 || len(driver.applied) != 3 {
+		t.Errorf("Expected nothing to be rolled back, got %v", driver.applied)
+	}
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Down, 2, testLogger, WithSkipMissingDown())
+	if err != nil {
+		t.Fatalf("Unexpected error while skipping missing migrations: %s", err)
+	}
+	if applied != 1 || !reflect.DeepEqual(driver.applied, []string{"001_init", "003_deleted"}) {
+		t.Errorf("Expected only 002_update to be rolled back, got %d: %v", applied, driver.applied)
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) contains(substr string) bool {
+	for _, message := range l.messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMigrateNothingPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "",
+			"001_init.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	logger := &recordingLogger{}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error while performing migration: %s", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied, %d applied.", applied)
+	}
+	if !reflect.DeepEqual(logger.messages, []string{"No pending migrations (up)"}) {
+		t.Errorf("Expected a log line saying there are no pending migrations, got %q", logger.messages)
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	planned, err := Plan(ctx, driver, memoryMigration, Up, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning migrations: %s", err)
+	}
+	if len(planned) != 2 || planned[0].ID != "001_init" || planned[1].ID != "002_update" {
+		t.Errorf("Expected 001_init and 002_update to be planned, got %v", planned)
+	}
+
+	applied, err := MigrateUp(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating up: %s", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected all migrations to be applied, got %d: %v", applied, driver.applied)
+	}
+
+	applied, err = MigrateDown(ctx, driver, memoryMigration)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating down: %s", err)
+	}
+	if applied != 2 || len(driver.applied) != 0 {
+		t.Errorf("Expected all migrations to be rolled back, got %d: %v", applied, driver.applied)
+	}
+}
+
+func TestEnsureAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+			"003_column.up.sql":   "",
+			"003_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	// Up needed
+	if err := EnsureAt(ctx, driver, memoryMigration, "002_update"); err != nil {
+		t.Fatalf("Unexpected error while migrating up to 002_update: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected migrations up to 002_update to be applied, got %v", driver.applied)
+	}
+
+	// Already there
+	if err := EnsureAt(ctx, driver, memoryMigration, "002_update"); err != nil {
+		t.Fatalf("Unexpected error while already at 002_update: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected applied migrations to be unchanged, got %v", driver.applied)
+	}
+
+	// Down needed
+	driver.applied = append(driver.applied, "003_column")
+	if err := EnsureAt(ctx, driver, memoryMigration, "001_init"); err != nil {
+		t.Fatalf("Unexpected error while migrating down to 001_init: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init"}) {
+		t.Errorf("Expected only 001_init to remain applied, got %v", driver.applied)
+	}
+
+	if err := EnsureAt(ctx, driver, memoryMigration, "004_unknown"); err == nil {
+		t.Error("Expected an error when the target migration is unknown")
+	}
+}
+
+func TestMigrateWithInjectedFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "-- +migration NoTransaction\nSELECT 1;\nSELECT 2;\n",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "-- +migration NoTransaction\nSELECT 3;\nSELECT 4;\n",
+			"002_update.down.sql": "",
+			"003_column.up.sql":   "-- +migration NoTransaction\nSELECT 5;\nSELECT 6;\n",
+			"003_column.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.failAfterNStatements = 3
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ID != "002_update" {
+		t.Fatalf("Expected 002_update to fail partway through, got %v", err)
+	}
+	if applied != 1 || !reflect.DeepEqual(driver.applied, []string{"001_init"}) {
+		t.Errorf("Expected only 001_init to be applied, got %d: %v", applied, driver.applied)
+	}
+
+	driver = getMockDriver()
+	driver.failOnVersionRecord = true
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithContinueOnMigrationError())
+	var failed MigrationErrors
+	if !errors.As(err, &failed) || len(failed) != 3 {
+		t.Fatalf("Expected all 3 migrations to fail recording their version, got %v", err)
+	}
+	if applied != 0 || len(driver.applied) != 0 {
+		t.Errorf("Expected no migrations to be recorded, got %d: %v", applied, driver.applied)
+	}
+}
+
+func TestMigrateRequireMigrations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"README.md": "not a migration",
+		},
+	}
+
+	applied, err := Migrate(ctx, getMockDriver(), memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Errorf("Unexpected error for an empty migration set by default: %s", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied, %d applied.", applied)
+	}
+
+	_, err = Migrate(ctx, getMockDriver(), memoryMigration, Up, 0, testLogger, WithRequireMigrations())
+	var noMigrationsErr *NoMigrationsError
+	if !errors.As(err, &noMigrationsErr) {
+		t.Errorf("Expected a NoMigrationsError, got %v", err)
+	}
+}
+
+func TestMigrateWithRequires(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":           "",
+			"001_init.down.sql":         "",
+			"002_feature.up.sql":        "-- requires: 004_shared_users\nALTER TABLE users ADD COLUMN email text;\n",
+			"002_feature.down.sql":      "",
+			"003_column.up.sql":         "",
+			"003_column.down.sql":       "",
+			"004_shared_users.up.sql":   "-- requires: 001_init\nCREATE TABLE users (id integer);\n",
+			"004_shared_users.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 2, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating up: %s", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "003_column"}) {
+		t.Errorf("Expected 001_init and 003_column to be applied first, got %d: %v", applied, driver.applied)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger); err != nil {
+		t.Fatalf("Unexpected error while migrating up: %s", err)
+	}
+	expected := []string{"001_init", "003_column", "004_shared_users", "002_feature"}
+	if !reflect.DeepEqual(driver.applied, expected) {
+		t.Errorf("Expected migrations to be applied in dependency order %v, got %v", expected, driver.applied)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Down, 1, testLogger); err != nil {
+		t.Fatalf("Unexpected error while migrating down: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, expected[:3]) {
+		t.Errorf("Expected 002_feature to be rolled back first, got %v", driver.applied)
+	}
+
+	memoryMigration.Files["001_init.up.sql"] = "-- requires: 002_feature\n"
+
+	_, err = Migrate(ctx, getMockDriver(), memoryMigration, Up, 0, testLogger)
+	var cycleErr *DependencyCycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected a DependencyCycleError, got %v", err)
+	}
+	if !reflect.DeepEqual(cycleErr.IDs, []string{"001_init", "002_feature", "004_shared_users"}) {
+		t.Errorf("Expected the cycle to include 001_init, 002_feature and 004_shared_users, got %v", cycleErr.IDs)
+	}
+
+	memoryMigration.Files["001_init.up.sql"] = "-- requires: 000_missing\n"
+
+	_, err = Migrate(ctx, getMockDriver(), memoryMigration, Up, 0, testLogger)
+	var missingErr *MissingDependencyError
+	if !errors.As(err, &missingErr) || missingErr.ID != "001_init" || missingErr.Requires != "000_missing" {
+		t.Errorf("Expected a MissingDependencyError for 000_missing, got %v", err)
+	}
+}
+
+func TestResolveID(t *testing.T) {
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"20230101120000_add_users.up.sql":       "",
+			"20230102120000_add_user_roles.up.sql":  "",
+			"20230103120000_add_orders.up.sql":      "",
+			"20230103130000_backfill_orders.up.sql": "",
+		},
+	}
+
+	tests := []struct {
+		prefix   string
+		expected string
+		err      bool
+	}{
+		{prefix: "20230101120000_add_users", expected: "20230101120000_add_users"},
+		{prefix: "20230102", expected: "20230102120000_add_user_roles"},
+		{prefix: "add_users", expected: "20230101120000_add_users"},
+		{prefix: "backfill", expected: "20230103130000_backfill_orders"},
+		{prefix: "add_user", err: true},
+		{prefix: "20230103", err: true},
+		{prefix: "drop_users", err: true},
+		{prefix: "", err: true},
+	}
+
+	for _, test := range tests {
+		id, err := ResolveID(memoryMigration, test.prefix)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected an error resolving %q, got %s", test.prefix, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error resolving %q: %s", test.prefix, err)
+		}
+		if id != test.expected {
+			t.Errorf("Expected %q to resolve to %s, got %s", test.prefix, test.expected, id)
+		}
+	}
+
+	_, err := ResolveID(memoryMigration, "orders")
+	var ambiguousErr *AmbiguousIDError
+	if !errors.As(err, &ambiguousErr) || !reflect.DeepEqual(ambiguousErr.IDs, []string{"20230103120000_add_orders", "20230103130000_backfill_orders"}) {
+		t.Errorf("Expected an AmbiguousIDError listing both order migrations, got %v", err)
+	}
+
+	var missingDownErr *MissingDownError
+	if _, err := ResolveID(memoryMigration, "add_users", WithStrictLoad()); !errors.As(err, &missingDownErr) {
+		t.Errorf("Expected a MissingDownError resolving an ID with strict loading, got %v", err)
+	}
+}
+
+func TestMigrateWithPreValidate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "-- +migration NoTransaction\nCREATE TABLE users (id integer);\n",
+			"002_update.up.sql": "-- +migration NoTransaction\nCREATE TABLE roles (id integer);\nCREAT syntax TABLE grants (id integer);\n",
+		},
+	}
+
+	driver := getMockDriver()
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithPreValidate())
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ID != "002_update" {
+		t.Fatalf("Expected 002_update to fail validation, got %v", err)
+	}
+	if applied != 0 || driver.statementsRun != 0 || len(driver.applied) != 0 {
+		t.Errorf("Expected no statements to run, %d statements ran and %d migrations were applied", driver.statementsRun, applied)
+	}
+
+	driver = getMockDriver()
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error without pre-validation: %s", err)
+	}
+	if applied != 2 {
+		t.Errorf("Expected both migrations to be applied without pre-validation, %d applied", applied)
+	}
+}
+
+func TestMigrateDownInProduction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "",
+			"001_init.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	_, err := Migrate(ctx, driver, memoryMigration, Down, 0, testLogger, WithEnvironment("Production"))
+	var downErr *DownNotAllowedError
+	if !errors.As(err, &downErr) {
+		t.Fatalf("Expected a DownNotAllowedError in production, got %v", err)
+	}
+	if len(driver.applied) != 1 {
+		t.Errorf("Expected nothing to be rolled back, got %v", driver.applied)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithEnvironment("production")); err != nil {
+		t.Errorf("Unexpected error migrating up in production: %s", err)
+	}
+
+	if _, err := Migrate(ctx, driver, memoryMigration, Down, 0, testLogger, WithEnvironment("staging")); err != nil {
+		t.Errorf("Unexpected error migrating down outside production: %s", err)
+	}
+
+	driver.applied = []string{"001_init"}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Down, 0, testLogger, WithEnvironment("production"), WithAllowDownInProduction(true))
+	if err != nil {
+		t.Errorf("Unexpected error migrating down when explicitly allowed: %s", err)
+	}
+	if applied != 1 || len(driver.applied) != 0 {
+		t.Errorf("Expected 001_init to be rolled back, got %d: %v", applied, driver.applied)
+	}
+}
+
+func TestMigrateIDsDownInProduction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "",
+			"001_init.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	err := MigrateIDs(ctx, driver, memoryMigration, []string{"001_init"}, Down, WithEnvironment("production"))
+	var downErr *DownNotAllowedError
+	if !errors.As(err, &downErr) {
+		t.Fatalf("Expected a DownNotAllowedError in production, got %v", err)
+	}
+	if len(driver.applied) != 1 {
+		t.Errorf("Expected nothing to be rolled back, got %v", driver.applied)
+	}
+
+	err = MigrateIDs(ctx, driver, memoryMigration, []string{"001_init"}, Down, WithEnvironment("production"), WithAllowDownInProduction(true))
+	if err != nil {
+		t.Errorf("Unexpected error migrating down when explicitly allowed: %s", err)
+	}
+	if len(driver.applied) != 0 {
+		t.Errorf("Expected 001_init to be rolled back, got %v", driver.applied)
+	}
+}
+
+func TestEnsureAtDownInProduction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "",
+			"001_init.down.sql":   "",
+			"002_update.up.sql":   "",
+			"002_update.down.sql": "",
+		},
+	}
+
+	driver := getMockDriver()
+
+	if err := EnsureAt(ctx, driver, memoryMigration, "002_update", WithEnvironment("production")); err != nil {
+		t.Fatalf("Unexpected error migrating up in production: %s", err)
+	}
+
+	err := EnsureAt(ctx, driver, memoryMigration, "001_init", WithEnvironment("production"))
+	var downErr *DownNotAllowedError
+	if !errors.As(err, &downErr) {
+		t.Fatalf("Expected a DownNotAllowedError in production, got %v", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected nothing to be rolled back, got %v", driver.applied)
+	}
+
+	err = EnsureAt(ctx, driver, memoryMigration, "001_init", WithEnvironment("production"), WithAllowDownInProduction(true))
+	if err != nil {
+		t.Errorf("Unexpected error migrating down when explicitly allowed: %s", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init"}) {
+		t.Errorf("Expected only 001_init to remain applied, got %v", driver.applied)
+	}
+}
+
+func TestMigrateCommitEvery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "",
+			"002_update.up.sql": "",
+			"003_column.up.sql": "",
+			"004_index.up.sql":  "",
+			"005_seed.up.sql":   "",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.transactionalDDL = true
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithCommitEvery(2))
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if applied != 5 {
+		t.Errorf("Expected 5 migrations to be applied, %d applied", applied)
+	}
+
+	expected := [][]string{{"001_init", "002_update"}, {"003_column", "004_index"}, {"005_seed"}}
+	if !reflect.DeepEqual(driver.batches, expected) {
+		t.Errorf("Expected migrations to be committed in groups %v, got %v", expected, driver.batches)
+	}
+
+	memoryMigration.Files["004_index.up.sql"] = "error"
+
+	driver = getMockDriver()
+	driver.transactionalDDL = true
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithCommitEvery(2))
+	if err == nil {
+		t.Fatal("Expected an error when a migration in the second group fails")
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update"}) {
+		t.Errorf("Expected only the first group to stay applied, got %d: %v", applied, driver.applied)
+	}
+
+	_, err = Migrate(ctx, getMockDriver(), memoryMigration, Up, 0, testLogger, WithCommitEvery(2))
+	if err == nil {
+		t.Error("Expected an error for a driver without transactional DDL")
+	}
+}
+
+func TestMigrateBatchSmallMigrations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":     "CREATE TABLE users (id integer);\n",
+			"002_update.up.sql":   "CREATE TABLE roles (id integer);\n",
+			"003_column.up.sql":   "ALTER TABLE users ADD COLUMN name text;\nALTER TABLE users ADD COLUMN email text;\n",
+			"004_backfill.up.sql": "UPDATE users SET name = 'a';\nUPDATE users SET name = 'b';\nUPDATE users SET name = 'c';\nUPDATE users SET name = 'd';\n",
+			"005_seed.up.sql":     "INSERT INTO roles VALUES (1);\n",
+			"006_grants.up.sql":   "CREATE TABLE grants (id integer);\n",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.transactionalDDL = true
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithBatchSmallMigrations(3))
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if applied != 6 {
+		t.Errorf("Expected 6 migrations to be applied, %d applied", applied)
+	}
+
+	expected := [][]string{{"001_init", "002_update"}, {"003_column"}, {"004_backfill"}, {"005_seed", "006_grants"}}
+	if !reflect.DeepEqual(driver.batches, expected) {
+		t.Errorf("Expected migrations to be committed in chunks %v, got %v", expected, driver.batches)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "002_update", "003_column", "004_backfill", "005_seed", "006_grants"}) {
+		t.Errorf("Expected all migrations to be applied in order, got %v", driver.applied)
+	}
+
+	driver = getMockDriver()
+	driver.transactionalDDL = true
+
+	_, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithBatchSmallMigrations(3), WithCommitEvery(1))
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if len(driver.batches) != 6 {
+		t.Errorf("Expected WithCommitEvery to limit chunks to 1 migration, got %v", driver.batches)
+	}
+}
+
+func TestMigrateWithGuard(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":        "CREATE TABLE users (id integer);\n",
+			"002_backfill.up.sql":    "UPDATE users SET active = true;\n",
+			"002_backfill.guard.sql": "SELECT count(*) < 1000 FROM users;\n",
+			"003_index.up.sql":       "CREATE INDEX users_active ON users (active);\n",
+			"003_index.guard.sql":    "SELECT false;\n",
+		},
+	}
+
+	m, err := LoadMigrations(memoryMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m[1].Guard == nil || m[1].Guard.Statements[0] != "SELECT count(*) < 1000 FROM users;\n" {
+		t.Errorf("Expected the guard of 002_backfill to be loaded, got %v", m[1].Guard)
+	}
+	if m[0].Guard != nil {
+		t.Errorf("Expected 001_init to have no guard, got %v", m[0].Guard)
+	}
+
+	driver := getMockDriver()
+	logger := &recordingLogger{}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_backfill"}) {
+		t.Errorf("Expected the migration whose guard passes to be applied, got %d: %v", applied, driver.applied)
+	}
+	if !logger.contains("Skipping migration (up) named '003_index': its guard did not pass") {
+		t.Errorf("Expected the skipped migration to be logged, got %v", logger.messages)
+	}
+
+	memoryMigration.Files["003_index.guard.sql"] = "SELECT true;\n"
+
+	applied, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if applied != 1 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_backfill", "003_index"}) {
+		t.Errorf("Expected the skipped migration to be applied once its guard passes, got %d: %v", applied, driver.applied)
+	}
+
+	memoryMigration.Files["004_seed.up.sql"] = "INSERT INTO users VALUES (1);\n"
+	memoryMigration.Files["004_seed.guard.sql"] = "SELECT error;\n"
+
+	_, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ID != "004_seed" {
+		t.Errorf("Expected a MigrationError for the failing guard of 004_seed, got %v", err)
+	}
+}
+
+func TestStrictLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   bool
+	}{
+		{
+			name: "missing down",
+			files: map[string]string{
+				"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+				"001_init.down.sql": "DROP TABLE users;\n",
+				"002_roles.up.sql":  "CREATE TABLE roles (id integer);\n",
+			},
+			err: true,
+		},
+		{
+			name: "irreversible",
+			files: map[string]string{
+				"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+				"001_init.down.sql": "DROP TABLE users;\n",
+				"002_drop.up.sql":   "-- +migration Irreversible\nDROP TABLE legacy_users;\n",
+			},
+		},
+		{
+			name: "complete",
+			files: map[string]string{
+				"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+				"001_init.down.sql": "DROP TABLE users;\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := LoadMigrations(&MemoryMigrationSource{Files: test.files}, WithStrictLoad())
+
+		var missingDown *MissingDownError
+		if test.err {
+			if !errors.As(err, &missingDown) || missingDown.ID != "002_roles" {
+				t.Errorf("%s: Expected a MissingDownError for 002_roles, got %v", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Unexpected error loading migrations: %s", test.name, err)
+		}
+
+		if _, err := LoadMigrations(&MemoryMigrationSource{Files: test.files}); err != nil {
+			t.Errorf("%s: Expected no error without WithStrictLoad, got %s", test.name, err)
+		}
+	}
+}
+
+func TestLoadPostHook(t *testing.T) {
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":       "CREATE TABLE users (id integer);\n",
+			"002_backfill.up.sql":   "INSERT INTO users SELECT generate_series(1, 1000000);\n",
+			"002_backfill.post.sql": "ANALYZE users;\nVACUUM users;\n",
+		},
+	}
+
+	m, err := LoadMigrations(memoryMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m[0].PostHook != nil {
+		t.Errorf("Expected 001_init to have no post-hook, got %v", m[0].PostHook)
+	}
+	if !reflect.DeepEqual(m[1].PostHook, []string{"ANALYZE users;", "\nVACUUM users;\n"}) {
+		t.Errorf("Expected the post-hook of 002_backfill to be split into statements, got %q", m[1].PostHook)
+	}
+}
+
+func TestMigrateChecksumMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+			"002_update.up.sql": "CREATE TABLE roles (id integer);\n",
+			"003_column.up.sql": "CREATE TABLE grants (id integer);\n",
+		},
+	}
+
+	m, err := LoadMigrations(memoryMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDriver := func() *mockDriver {
+		driver := getMockDriver()
+		driver.applied = []string{"001_init", "002_update"}
+		driver.checksums = map[string]string{
+			"001_init":   m[0].Checksum(),
+			"002_update": "edited",
+		}
+		return driver
+	}
+
+	driver := newDriver()
+	_, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	var mismatchErr *ChecksumMismatchError
+	if !errors.As(err, &mismatchErr) || !reflect.DeepEqual(mismatchErr.IDs, []string{"002_update"}) {
+		t.Fatalf("Expected a ChecksumMismatchError for 002_update by default, got %v", err)
+	}
+	if len(driver.applied) != 2 {
+		t.Errorf("Expected nothing to be applied on a mismatch, got %v", driver.applied)
+	}
+
+	logger := &recordingLogger{}
+	driver = newDriver()
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger, WithChecksumMode(ChecksumWarn))
+	if err != nil {
+		t.Fatalf("Unexpected error with ChecksumWarn: %s", err)
+	}
+	if applied != 1 {
+		t.Errorf("Expected 003_column to be applied with ChecksumWarn, %d applied", applied)
+	}
+	if !logger.contains("'002_update' has been edited") {
+		t.Errorf("Expected a warning about 002_update, got %q", logger.messages)
+	}
+
+	logger = &recordingLogger{}
+	driver = newDriver()
+	if _, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger, WithChecksumMode(ChecksumOff)); err != nil {
+		t.Fatalf("Unexpected error with ChecksumOff: %s", err)
+	}
+	if logger.contains("has been edited") {
+		t.Errorf("Expected no warning with ChecksumOff, got %q", logger.messages)
+	}
+}
+
+func TestMigrateInvalidDirection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	invalid := Direction(2)
+	if invalid.String() != "directionless" {
+		t.Errorf("Expected an invalid direction to be directionless, got %s", invalid)
+	}
+
+	driver := getMockDriver()
+
+	err := driver.Migrate(ctx, &PlannedMigration{
+		Migration: &Migration{ID: "001_init"},
+		Direction: invalid,
+	})
+	var directionErr *InvalidDirectionError
+	if !errors.As(err, &directionErr) {
+		t.Errorf("Expected an InvalidDirectionError from the driver, got %v", err)
+	}
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql": "",
+		},
+	}
+
+	_, err = Migrate(ctx, driver, memoryMigration, invalid, 0, testLogger)
+	if !errors.As(err, &directionErr) {
+		t.Errorf("Expected an InvalidDirectionError from Migrate, got %v", err)
+	}
+	if len(driver.applied) != 0 {
+		t.Errorf("Expected nothing to be applied, got %v", driver.applied)
+	}
+}
+
+func TestMigrateWithConfirm(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":  "CREATE TABLE users (id integer);\n",
+			"002_roles.up.sql": "CREATE TABLE roles (id integer);\n",
+			"003_index.up.sql": "CREATE INDEX users_id ON users (id);\n",
+		},
+	}
+
+	var asked []string
+	confirm := func(migration *PlannedMigration) (bool, error) {
+		asked = append(asked, migration.ID)
+		return migration.ID == "001_init", nil
+	}
+
+	driver := getMockDriver()
+	logger := &recordingLogger{}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger, WithConfirm(confirm))
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if applied != 1 || !reflect.DeepEqual(driver.applied, []string{"001_init"}) {
+		t.Errorf("Expected only the confirmed migration to be applied, got %d: %v", applied, driver.applied)
+	}
+	if !reflect.DeepEqual(asked, []string{"001_init", "002_roles"}) {
+		t.Errorf("Expected the run to stop after the declined migration, asked for %v", asked)
+	}
+	if !logger.contains("Migration (up) named '002_roles' was not confirmed, stopping") {
+		t.Errorf("Expected the declined migration to be logged, got %v", logger.messages)
+	}
+
+	aborted := errors.New("aborted")
+	_, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger, WithConfirm(func(*PlannedMigration) (bool, error) {
+		return false, aborted
+	}))
+	if !errors.Is(err, aborted) {
+		t.Errorf("Expected the confirmation error to abort the run, got %v", err)
+	}
+	if !reflect.DeepEqual(driver.applied, []string{"001_init"}) {
+		t.Errorf("Expected no migration to be applied after an aborted confirmation, got %v", driver.applied)
+	}
+}
+
+func TestMigrateWithVerify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":       "CREATE TABLE users (id integer);\n",
+			"001_init.verify.sql":   "SELECT to_regclass('users') IS NOT NULL;\n",
+			"002_active.up.sql":     "ALTER TABLE users ADD COLUMN active boolean;\n",
+			"002_active.verify.sql": "SELECT true;\nSELECT column_default = 'false' FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'active';\n",
+			"003_index.up.sql":      "CREATE INDEX users_active ON users (active);\n",
+		},
+	}
+
+	m, err := LoadMigrations(memoryMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m[1].Verify) != 2 {
+		t.Errorf("Expected 2 verification queries for 002_active, got %q", m[1].Verify)
+	}
+
+	driver := getMockDriver()
+	logger := &recordingLogger{}
+
+	applied, err := Migrate(ctx, driver, memoryMigration, Up, 0, logger)
+	var verifyErr *PostVerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("Expected a PostVerifyError, got %v", err)
+	}
+	if verifyErr.ID != "002_active" {
+		t.Errorf("Expected the verification of 002_active to fail, got %s", verifyErr.ID)
+	}
+	if applied != 2 || !reflect.DeepEqual(driver.applied, []string{"001_init", "002_active"}) {
+		t.Errorf("Expected the failed migration to stay applied and the run to stop, got %d: %v", applied, driver.applied)
+	}
+	if !logger.contains("Verified migration (up) named '001_init'") {
+		t.Errorf("Expected the passing verification to be logged, got %v", logger.messages)
+	}
+}
+
+func TestRollbackPlan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":    "CREATE TABLE users (id integer);\n",
+			"001_init.down.sql":  "DROP TABLE users;\n",
+			"002_roles.up.sql":   "CREATE TABLE roles (id integer);\n",
+			"002_roles.down.sql": "DROP TABLE roles;\n",
+			"003_index.up.sql":   "CREATE INDEX users_id ON users (id);\n",
+			"003_index.down.sql": "DROP INDEX users_id;\n",
+		},
+	}
+
+	driver := getMockDriver()
+	// 002_roles was merged late, so it was applied last.
+	driver.applied = []string{"001_init", "003_index", "002_roles"}
+
+	planned, err := RollbackPlan(ctx, driver, memoryMigration, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning the rollback: %s", err)
+	}
+
+	var ids []string
+	for _, plannedMigration := range planned {
+		if plannedMigration.Direction != Down {
+			t.Errorf("Expected %s to be planned down, got %s", plannedMigration.ID, plannedMigration.Direction)
+		}
+		ids = append(ids, plannedMigration.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"002_roles", "003_index"}) {
+		t.Errorf("Expected the 2 most recently applied migrations in reverse order, got %v", ids)
+	}
+
+	planned, err = RollbackPlan(ctx, driver, memoryMigration, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning the rollback: %s", err)
+	}
+	if len(planned) != 3 || planned[2].ID != "001_init" {
+		t.Errorf("Expected every applied migration to be planned, got %v", planned)
+	}
+
+	if !reflect.DeepEqual(driver.applied, []string{"001_init", "003_index", "002_roles"}) {
+		t.Errorf("Expected nothing to be rolled back, got %v", driver.applied)
+	}
+}
+
+func TestLoadFrontMatter(t *testing.T) {
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_roles.up.sql": "CREATE ROLE reader;\n",
+			"002_users.up.sql": "/* ---\ndescription: Create the users table\nuse_transaction: false\ntags: [schema, users]\n--- */\nCREATE TABLE users (id integer);\n",
+			"003_index.up.sql": "/* ---\nrequires:\n  - 002_users\n--- */\nCREATE INDEX CONCURRENTLY users_id ON users (id);\n",
+		},
+	}
+
+	m, err := LoadMigrations(memoryMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := m[1]
+	if users.ID != "002_users" || users.Description != "Create the users table" || !reflect.DeepEqual(users.Tags, []string{"schema", "users"}) || users.Up.UseTransaction {
+		t.Errorf("Expected 002_users to be loaded with its front-matter, got %s %q %q transaction=%t", users.ID, users.Description, users.Tags, users.Up.UseTransaction)
+	}
+	if !reflect.DeepEqual(users.Up.Statements, []string{"CREATE TABLE users (id integer);\n"}) {
+		t.Errorf("Expected the front-matter to be stripped from the statements, got %q", users.Up.Statements)
+	}
+	if index := m[2]; !reflect.DeepEqual(index.Requires, []string{"002_users"}) || index.Tags != nil {
+		t.Errorf("Expected 003_index to require 002_users without tags, got %q %q", index.Requires, index.Tags)
+	}
+
+	memoryMigration.Files["004_broken.up.sql"] = "/* ---\nuse_transaction: maybe\n--- */\nSELECT 1;\n"
+
+	if _, err := LoadMigrations(memoryMigration); err == nil || !strings.Contains(err.Error(), "004_broken") {
+		t.Errorf("Expected an error naming the migration with malformed front-matter, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package migrationtest provides helpers for testing code that runs
+// migrations, without a database.
+package migrationtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/muxinc/migration"
+)
+
+// MemoryDriver is a migration.Driver recording applied versions in memory. It
+// runs no statements, but records them so tests can inspect what would have
+// been run. It is safe for concurrent use.
+//
+// Close does nothing, so a MemoryDriver can still be inspected after
+// migration.Migrate closes it.
+type MemoryDriver struct {
+	mu       sync.Mutex
+	applied  []string
+	executed []string
+	failures map[string]error
+}
+
+// NewMemoryDriver returns a MemoryDriver with no applied migrations.
+func NewMemoryDriver() *MemoryDriver {
+	return &MemoryDriver{
+		applied:  []string{},
+		failures: map[string]error{},
+	}
+}
+
+// FailOn makes the driver return err whenever the migration id is run, in
+// either direction, leaving the applied versions untouched. A nil err clears
+// a previously injected failure.
+func (d *MemoryDriver) FailOn(id string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		delete(d.failures, id)
+		return
+	}
+	d.failures[id] = err
+}
+
+// Applied returns the IDs of the applied migrations, in the order they were
+// applied.
+func (d *MemoryDriver) Applied() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string{}, d.applied...)
+}
+
+// Executed returns the statements of every migration run successfully, in
+// the order they were run.
+func (d *MemoryDriver) Executed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string{}, d.executed...)
+}
+
+// Close does nothing.
+func (d *MemoryDriver) Close(ctx context.Context) error {
+	return nil
+}
+
+// Migrate records the migration as applied, or removes it when migrating
+// down, unless a failure was injected for it with FailOn.
+func (d *MemoryDriver) Migrate(ctx context.Context, planned *migration.PlannedMigration) error {
+	if planned.Direction != migration.Up && planned.Direction != migration.Down {
+		return &migration.InvalidDirectionError{Direction: planned.Direction}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.failures[planned.ID]; err != nil {
+		return err
+	}
+
+	statements := planned.Up
+	if planned.Direction == migration.Down {
+		statements = planned.Down
+	}
+	if statements != nil {
+		d.executed = append(d.executed, statements.Statements...)
+	}
+
+	versionIndex := -1
+	for i, version := range d.applied {
+		if version == planned.ID {
+			versionIndex = i
+			break
+		}
+	}
+
+	if planned.Direction == migration.Up {
+		if versionIndex == -1 {
+			d.applied = append(d.applied, planned.ID)
+		}
+	} else if versionIndex != -1 {
+		d.applied = append(d.applied[:versionIndex], d.applied[versionIndex+1:]...)
+	}
+
+	return nil
+}
+
+// Versions returns the IDs of the applied migrations, in the order they were
+// applied.
+func (d *MemoryDriver) Versions(ctx context.Context) ([]string, error) {
+	return d.Applied(), nil
+}
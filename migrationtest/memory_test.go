@@ -0,0 +1,97 @@
+package migrationtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/muxinc/migration"
+)
+
+var source = &migration.MemoryMigrationSource{
+	Files: map[string]string{
+		"1_init.up.sql":    "CREATE TABLE users (id integer);\n",
+		"1_init.down.sql":  "DROP TABLE users;\n",
+		"2_roles.up.sql":   "CREATE TABLE roles (id integer);\n",
+		"2_roles.down.sql": "DROP TABLE roles;\n",
+	},
+}
+
+func TestMemoryDriverApply(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	driver := NewMemoryDriver()
+
+	count, err := migration.Migrate(ctx, driver, source, migration.Up, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 migrations to be applied, got %d", count)
+	}
+
+	if applied := driver.Applied(); !reflect.DeepEqual(applied, []string{"1_init", "2_roles"}) {
+		t.Errorf("Expected 1_init and 2_roles to be applied, got %v", applied)
+	}
+
+	expected := []string{"CREATE TABLE users (id integer);\n", "CREATE TABLE roles (id integer);\n"}
+	if executed := driver.Executed(); !reflect.DeepEqual(executed, expected) {
+		t.Errorf("Expected statements %q to be executed, got %q", expected, executed)
+	}
+}
+
+func TestMemoryDriverRollback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	driver := NewMemoryDriver()
+
+	if _, err := migration.Migrate(ctx, driver, source, migration.Up, 0, nil); err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+
+	count, err := migration.Migrate(ctx, driver, source, migration.Down, 1, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error while rolling back: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 migration to be rolled back, got %d", count)
+	}
+
+	if applied := driver.Applied(); !reflect.DeepEqual(applied, []string{"1_init"}) {
+		t.Errorf("Expected only 1_init to remain applied, got %v", applied)
+	}
+}
+
+func TestMemoryDriverFailOn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	driver := NewMemoryDriver()
+
+	injected := errors.New("injected failure")
+	driver.FailOn("2_roles", injected)
+
+	count, err := migration.Migrate(ctx, driver, source, migration.Up, 0, nil)
+	if !errors.Is(err, injected) {
+		t.Fatalf("Expected the injected failure, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 migration to be applied before the failure, got %d", count)
+	}
+	if applied := driver.Applied(); !reflect.DeepEqual(applied, []string{"1_init"}) {
+		t.Errorf("Expected only 1_init to be applied, got %v", applied)
+	}
+
+	driver.FailOn("2_roles", nil)
+
+	if _, err := migration.Migrate(ctx, driver, source, migration.Up, 0, nil); err != nil {
+		t.Fatalf("Unexpected error once the failure is cleared: %s", err)
+	}
+	if applied := driver.Applied(); !reflect.DeepEqual(applied, []string{"1_init", "2_roles"}) {
+		t.Errorf("Expected 1_init and 2_roles to be applied, got %v", applied)
+	}
+}
@@ -4,12 +4,34 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync/atomic"
 
 	"github.com/muxinc/migration/parser"
 )
 
 type mockDriver struct {
-	applied []string
+	applied  []string
+	executed []string
+
+	transactionalDDL bool
+	checksums        map[string]string
+	batches          [][]string
+
+	// repeatables holds the checksums of the applied repeatable migrations.
+	repeatables map[string]string
+
+	serverVersion string
+
+	// failAfterNStatements makes Migrate fail once this many statements have
+	// been run across all migrations, if positive. failOnVersionRecord makes
+	// Migrate fail after running the statements, when recording the version.
+	failAfterNStatements int
+	failOnVersionRecord  bool
+	statementsRun        int
+
+	lock    chan struct{}
+	running int32
+	overlap bool
 }
 
 func (m *mockDriver) Close(ctx context.Context) error {
@@ -17,6 +39,15 @@ func (m *mockDriver) Close(ctx context.Context) error {
 }
 
 func (m *mockDriver) Migrate(ctx context.Context, migration *PlannedMigration) error {
+	if migration.Direction != Up && migration.Direction != Down {
+		return &InvalidDirectionError{Direction: migration.Direction}
+	}
+
+	if atomic.AddInt32(&m.running, 1) > 1 {
+		m.overlap = true
+	}
+	defer atomic.AddInt32(&m.running, -1)
+
 	var migrationStatements *parser.ParsedMigration
 
 	if migration.Direction == Up {
@@ -35,6 +66,17 @@ func (m *mockDriver) Migrate(ctx context.Context, migration *PlannedMigration) e
 		return errors.New("error executing migration")
 	}
 
+	for range migrationStatements.Statements {
+		if m.failAfterNStatements > 0 && m.statementsRun >= m.failAfterNStatements {
+			return errors.New("error executing statement")
+		}
+		m.statementsRun++
+	}
+
+	if m.failOnVersionRecord {
+		return errors.New("error recording version")
+	}
+
 	versionIndex := -1
 
 	for i, version := range m.applied {
@@ -57,12 +99,142 @@ func (m *mockDriver) Migrate(ctx context.Context, migration *PlannedMigration) e
 	return nil
 }
 
+func (m *mockDriver) SupportsTransactionalDDL() bool {
+	return m.transactionalDDL
+}
+
+func (m *mockDriver) MigrateBatch(ctx context.Context, migrations []*PlannedMigration) error {
+	applied := append([]string{}, m.applied...)
+
+	var batch []string
+	for _, migration := range migrations {
+		batch = append(batch, migration.ID)
+	}
+	m.batches = append(m.batches, batch)
+
+	for _, migration := range migrations {
+		if err := m.Migrate(ctx, migration); err != nil {
+			m.applied = applied
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *mockDriver) MigrateWithSavepoints(ctx context.Context, migrations []*PlannedMigration, onError func(migration *PlannedMigration, err error) bool) error {
+	var batch []string
+	for _, migration := range migrations {
+		batch = append(batch, migration.ID)
+	}
+	m.batches = append(m.batches, batch)
+
+	for _, migration := range migrations {
+		savepoint := append([]string{}, m.applied...)
+		if err := m.Migrate(ctx, migration); err != nil {
+			m.applied = savepoint
+			if !onError(migration, err) {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *mockDriver) Exec(ctx context.Context, statements *parser.ParsedMigration) error {
+	for _, statement := range statements.Statements {
+		if strings.Contains(statement, "error") {
+			return errors.New("error executing statement")
+		}
+	}
+
+	m.executed = append(m.executed, statements.Statements...)
+
+	return nil
+}
+
+func (m *mockDriver) ValidateStatements(ctx context.Context, statements *parser.ParsedMigration) error {
+	for _, statement := range statements.Statements {
+		if strings.Contains(statement, "syntax") {
+			return errors.New("syntax error in statement")
+		}
+	}
+
+	return nil
+}
+
+func (m *mockDriver) EvaluateGuard(ctx context.Context, guard *parser.ParsedMigration) (bool, error) {
+	for _, statement := range guard.Statements {
+		if strings.Contains(statement, "error") {
+			return false, errors.New("error in guard")
+		}
+		if strings.Contains(statement, "false") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (m *mockDriver) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	checksums := map[string]string{}
+	for id, checksum := range m.repeatables {
+		checksums[id] = checksum
+	}
+	return checksums, nil
+}
+
+func (m *mockDriver) MigrateRepeatable(ctx context.Context, migration *PlannedMigration) error {
+	for _, statement := range migration.Up.Statements {
+		if strings.Contains(statement, "error") {
+			return errors.New("error executing repeatable migration")
+		}
+	}
+
+	m.executed = append(m.executed, migration.Up.Statements...)
+
+	if m.repeatables == nil {
+		m.repeatables = map[string]string{}
+	}
+	m.repeatables[migration.ID] = migration.Checksum()
+
+	return nil
+}
+
+func (m *mockDriver) ServerVersion(ctx context.Context) (string, error) {
+	return m.serverVersion, nil
+}
+
+func (m *mockDriver) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	var applied []AppliedMigration
+	for _, id := range m.applied {
+		applied = append(applied, AppliedMigration{ID: id, Checksum: m.checksums[id]})
+	}
+	return applied, nil
+}
+
 func (m *mockDriver) Versions(ctx context.Context) ([]string, error) {
 	return m.applied, nil
 }
 
+func (m *mockDriver) Lock(ctx context.Context) error {
+	select {
+	case m.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return &LockTimeoutError{Name: "mock"}
+	}
+}
+
+func (m *mockDriver) Unlock(ctx context.Context) error {
+	<-m.lock
+	return nil
+}
+
 func getMockDriver() *mockDriver {
 	return &mockDriver{
 		applied: []string{},
+		lock:    make(chan struct{}, 1),
 	}
 }
@@ -0,0 +1,242 @@
+package migration
+
+// Option configures optional behaviour of Migrate.
+type Option func(*options)
+
+type options struct {
+	strictOrder       bool
+	continueOnError   bool
+	singleTransaction bool
+	savepoints        bool
+	templating        bool
+	autoDown          bool
+	skipMissingDown   bool
+	strictLoad        bool
+	requireMigrations bool
+	preValidate       bool
+	environment       string
+	allowProdDown     bool
+	commitEvery       int
+	batchStatements   int
+	checksumMode      ChecksumMode
+	templateData      interface{}
+	confirm           func(*PlannedMigration) (bool, error)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithStrictOrder refuses to apply migrations out of order.
+//
+// By default, Migrate applies unapplied migrations that precede the most
+// recently applied migration, which can happen when branches are merged. With
+// this option, Migrate instead returns a GapError listing those migrations.
+func WithStrictOrder() Option {
+	return func(o *options) {
+		o.strictOrder = true
+	}
+}
+
+// WithContinueOnMigrationError keeps applying migrations after one fails. The
+// failed migration is not recorded as applied, and once all planned migrations
+// have been attempted, Migrate returns a MigrationErrors listing each failure.
+//
+// This is UNSAFE for production use: later migrations usually depend on
+// earlier ones, so continuing can leave the schema in a state that no sequence
+// of migrations produces. It is intended for throwaway development databases.
+func WithContinueOnMigrationError() Option {
+	return func(o *options) {
+		o.continueOnError = true
+	}
+}
+
+// WithSingleTransaction applies all planned migrations within a single
+// transaction, so that either all of them are applied or none are.
+//
+// The driver must implement Batcher and report support for transactional DDL
+// through TransactionalDDL; otherwise Migrate returns an error before applying
+// anything, rather than producing a non-atomic result. It cannot be combined
+// with WithContinueOnMigrationError.
+func WithSingleTransaction() Option {
+	return func(o *options) {
+		o.singleTransaction = true
+	}
+}
+
+// WithSavepoints applies all planned migrations within a single transaction,
+// like WithSingleTransaction, but runs each migration in its own savepoint.
+// A failing migration is rolled back to its savepoint, leaving the migrations
+// applied before it in place. The run then stops, or, with
+// WithContinueOnMigrationError, goes on with the next migration. The
+// migrations that succeeded are committed together at the end, so if the
+// commit fails, none of them are applied.
+//
+// The driver must implement SavepointBatcher and report support for
+// transactional DDL through TransactionalDDL. It cannot be combined with
+// WithSingleTransaction, WithCommitEvery or WithBatchSmallMigrations.
+func WithSavepoints() Option {
+	return func(o *options) {
+		o.savepoints = true
+	}
+}
+
+// WithTemplate runs the contents of each migration file through text/template
+// with data before it is parsed, so that for example {{ .Partitions }} expands
+// to a deploy parameter. Referencing a field or key that data doesn't have
+// aborts the run with an error naming the migration.
+func WithTemplate(data interface{}) Option {
+	return func(o *options) {
+		o.templating = true
+		o.templateData = data
+	}
+}
+
+// WithAutoDown generates the down migration of migrations that don't have one,
+// using AutoDown. Loading migrations fails if a down migration is missing and
+// cannot be generated safely.
+func WithAutoDown() Option {
+	return func(o *options) {
+		o.autoDown = true
+	}
+}
+
+// WithSkipMissingDown skips, with a warning, the rollback of applied migrations
+// that are no longer in the migration set, for example because their files
+// were deleted. They remain recorded as applied, and count towards the maximum
+// number of migrations to roll back.
+//
+// By default, Migrate returns a MissingMigrationError before rolling anything
+// back.
+func WithSkipMissingDown() Option {
+	return func(o *options) {
+		o.skipMissingDown = true
+	}
+}
+
+// WithStrictLoad makes loading migrations fail with a MissingDownError if a
+// migration has an up migration but no down migration, unless the up
+// migration is marked with a "-- +migration Irreversible" line. This forces a
+// deliberate decision about how to roll back each migration. Down migrations
+// generated by WithAutoDown count as present.
+func WithStrictLoad() Option {
+	return func(o *options) {
+		o.strictLoad = true
+	}
+}
+
+// WithRequireMigrations returns a NoMigrationsError if the source contains no
+// migrations, which usually means they were not loaded, for example because of
+// a typo in a path. By default, an empty source is not an error.
+func WithRequireMigrations() Option {
+	return func(o *options) {
+		o.requireMigrations = true
+	}
+}
+
+// WithPreValidate checks the statements of every planned migration for syntax
+// errors before applying any of them, so that a typo in a later statement or
+// migration fails the run before the schema is touched. Validation has no side
+// effects.
+//
+// It requires the driver to implement Validator. For other drivers, a message
+// is logged and migrations are applied without validation.
+func WithPreValidate() Option {
+	return func(o *options) {
+		o.preValidate = true
+	}
+}
+
+// WithEnvironment sets the environment migrations run in. In the production
+// environment, Migrate, MigrateIDs and EnsureAt refuse to migrate down with a
+// DownNotAllowedError unless WithAllowDownInProduction permits it. The environment is compared
+// case-insensitively.
+func WithEnvironment(environment string) Option {
+	return func(o *options) {
+		o.environment = environment
+	}
+}
+
+// WithAllowDownInProduction sets whether migrating down is allowed when the
+// environment set by WithEnvironment is production. It is not allowed by
+// default, as rolling back in production usually loses data.
+func WithAllowDownInProduction(allow bool) Option {
+	return func(o *options) {
+		o.allowProdDown = allow
+	}
+}
+
+// WithCommitEvery applies migrations in transactions of up to n consecutive
+// migrations, recording their versions together, rather than in one
+// transaction per migration. This speeds up runs of many small migrations,
+// such as a large backfill split into parts. If a migration fails, only its
+// group is rolled back; earlier groups stay committed.
+//
+// Migrations that don't use a transaction are applied on their own. Like
+// WithSingleTransaction, which takes precedence, it requires a driver that
+// supports transactional DDL and implements Batcher, and cannot be combined
+// with WithContinueOnMigrationError.
+func WithCommitEvery(n int) Option {
+	return func(o *options) {
+		o.commitEvery = n
+	}
+}
+
+// WithBatchSmallMigrations applies consecutive migrations in shared
+// transactions as long as their combined number of statements doesn't exceed
+// maxStatements, committing whenever adding the next migration would. Runs of
+// many tiny migrations then pay for a transaction per chunk rather than per
+// migration, while a migration with more than maxStatements statements still
+// gets a transaction of its own. If a migration fails, only its chunk is
+// rolled back; earlier chunks stay committed.
+//
+// It can be combined with WithCommitEvery, in which case chunks are also
+// committed after n migrations. It has the same requirements as
+// WithCommitEvery.
+func WithBatchSmallMigrations(maxStatements int) Option {
+	return func(o *options) {
+		o.batchStatements = maxStatements
+	}
+}
+
+// WithConfirm calls confirm before applying each planned migration, for
+// example to prompt an operator during a risky production run. If confirm
+// returns false, the migration and all following ones are skipped, as
+// applying later migrations would leave a gap, and Migrate returns the
+// migrations applied so far without an error. If confirm returns an error,
+// Migrate aborts with a MigrationError wrapping it.
+//
+// With WithSingleTransaction, WithCommitEvery or WithBatchSmallMigrations,
+// every migration is confirmed before the first one is applied.
+func WithConfirm(confirm func(*PlannedMigration) (bool, error)) Option {
+	return func(o *options) {
+		o.confirm = confirm
+	}
+}
+
+// ChecksumMode determines what Migrate does when an applied migration has
+// been edited since it was applied.
+type ChecksumMode int
+
+const (
+	// ChecksumError refuses to migrate, returning a ChecksumMismatchError.
+	ChecksumError ChecksumMode = iota
+	// ChecksumWarn logs a warning for each edited migration and migrates.
+	ChecksumWarn
+	// ChecksumOff skips checking checksums.
+	ChecksumOff
+)
+
+// WithChecksumMode sets what happens when the checksum recorded for an applied
+// migration differs from the migration's current Checksum. By default,
+// ChecksumError is used. Checksums are only checked if the driver implements
+// AppliedMigrationLister, and only for migrations it recorded a checksum for.
+func WithChecksumMode(mode ChecksumMode) Option {
+	return func(o *options) {
+		o.checksumMode = mode
+	}
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	frontMatterStart = "/* ---"
+	frontMatterEnd   = "--- */"
+)
+
+// parseFrontMatter reads the front-matter at the start of contents, if any,
+// and returns a ParsedMigration populated from it along with the rest of
+// contents. Front-matter is a block comment delimited by "/* ---" and
+// "--- */" lines, holding YAML-style "key: value" lines:
+//
+//	/* ---
+//	description: Create the users table
+//	use_transaction: false
+//	tags: [schema, users]
+//	requires:
+//	  - 1_init
+//	--- */
+//
+// Lists are written inline in brackets, as comma-separated values or as
+// "- item" lines following the key. Blank lines and lines starting with "#"
+// are ignored.
+func parseFrontMatter(contents []byte) (*ParsedMigration, []byte, error) {
+	p := &ParsedMigration{
+		UseTransaction: true,
+		Statements:     []string{},
+	}
+
+	body := bytes.TrimLeft(contents, " \t\r\n")
+	if !isFrontMatterDelimiter(firstLine(string(body)), frontMatterStart) {
+		return p, contents, nil
+	}
+
+	lines := strings.SplitAfter(string(body), "\n")
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if isFrontMatterDelimiter(lines[i], frontMatterEnd) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return p, contents, fmt.Errorf("front-matter is not terminated by a %q line", frontMatterEnd)
+	}
+
+	var listKey string
+	for i := 1; i < end; i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			if listKey == "" {
+				return p, contents, fmt.Errorf("front-matter line %d: list item %q does not follow a list key", i+1, line)
+			}
+			if item := unquote(strings.TrimSpace(strings.TrimPrefix(line, "-"))); item != "" {
+				appendFrontMatterList(p, listKey, item)
+			}
+			continue
+		}
+		listKey = ""
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return p, contents, fmt.Errorf("front-matter line %d: expected \"key: value\", got %q", i+1, line)
+		}
+		key := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch key {
+		case "description":
+			p.Description = unquote(value)
+		case "use_transaction":
+			useTransaction, err := strconv.ParseBool(unquote(value))
+			if err != nil {
+				return p, contents, fmt.Errorf("front-matter line %d: use_transaction must be true or false, got %q", i+1, value)
+			}
+			p.UseTransaction = useTransaction
+		case "tags", "requires":
+			if value == "" {
+				listKey = key
+				continue
+			}
+			items, err := parseFrontMatterList(value)
+			if err != nil {
+				return p, contents, fmt.Errorf("front-matter line %d: %s", i+1, err)
+			}
+			for _, item := range items {
+				appendFrontMatterList(p, key, item)
+			}
+		default:
+			return p, contents, fmt.Errorf("front-matter line %d: unknown key %q, expected description, use_transaction, tags or requires", i+1, key)
+		}
+	}
+
+	return p, []byte(strings.Join(lines[end+1:], "")), nil
+}
+
+// isFrontMatterDelimiter reports whether line is delimiter, ignoring
+// whitespace, so that "/*---" also starts front-matter.
+func isFrontMatterDelimiter(line, delimiter string) bool {
+	return strings.Join(strings.Fields(line), "") == strings.Replace(delimiter, " ", "", -1)
+}
+
+// parseFrontMatterList parses an inline list, either in brackets, such as
+// "[a, b]", or bare, such as "a, b".
+func parseFrontMatterList(value string) ([]string, error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("list %q is missing its closing bracket", value)
+		}
+		value = value[1 : len(value)-1]
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = unquote(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func appendFrontMatterList(p *ParsedMigration, key, item string) {
+	if key == "tags" {
+		p.Tags = append(p.Tags, item)
+	} else {
+		p.Requires = append(p.Requires, item)
+	}
+}
+
+// unquote removes the single or double quotes around value, if any.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func FuzzSplitStatements(f *testing.F) {
+	seeds := []string{
+		"CREATE TABLE a (id integer); CREATE TABLE b (id integer);",
+		"INSERT INTO a (name) VALUES ('it''s; fine');",
+		"INSERT INTO a (name) VALUES (E'it\\'s; fine');",
+		`CREATE TABLE "odd;name" (id integer);`,
+		"CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END; $$ LANGUAGE plpgsql;",
+		"CREATE FUNCTION f() RETURNS void AS $body$ SELECT '$$;'; $body$ LANGUAGE sql;",
+		"SELECT $1; SELECT a$b; SELECT 1;",
+		"/* outer /* nested; */ still a comment; */ SELECT 1;",
+		"-- comment; with a semicolon\nSELECT 1;",
+		"SELECT 'unterminated;",
+		"SELECT 1;\n\n",
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, sql string) {
+		statements := SplitStatements(sql)
+
+		// Statements keep their terminators, so joining them without a
+		// separator must reproduce the input...
+		joined := strings.Join(statements, "")
+		if joined != sql {
+			t.Fatalf("joining statements %q did not reproduce input %q", statements, sql)
+		}
+
+		// ...and splitting the joined statements again must be stable.
+		if resplit := SplitStatements(joined); !reflect.DeepEqual(resplit, statements) {
+			t.Fatalf("re-splitting %q produced %q, expected %q", joined, resplit, statements)
+		}
+	})
+}
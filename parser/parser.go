@@ -6,55 +6,401 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
 	"strings"
+	"unicode"
 )
 
 const (
 	sqlCmdPrefix         = "-- +migration "
 	optionNoTransaction  = "NoTransaction"
+	optionIrreversible   = "Irreversible"
 	optionBeginStatement = "BeginStatement"
 	optionEndStatement   = "EndStatement"
+	optionDescription    = "Description:"
+	descriptionPrefix    = "-- description:"
+	optionRequires       = "Requires:"
+	requiresPrefix       = "-- requires:"
+
+	optionMinServerVersion = "MinServerVersion:"
+
+	// copyDataTerminator ends the data lines of a COPY ... FROM stdin
+	// statement.
+	copyDataTerminator = `\.`
 )
 
+// copyFromStdinRegex matches a COPY statement reading its data from the lines
+// following it, as written by pg_dump, possibly preceded by comment lines.
+var copyFromStdinRegex = regexp.MustCompile(`(?is)^(?:\s*--[^\n]*\n)*\s*COPY\s[^;]*\sFROM\s+STDIN\b[^;]*;$`)
+
 // ParsedMigration is a parsed migration
 type ParsedMigration struct {
 	UseTransaction bool
 	Statements     []string
+
+	// Description is taken from a "-- description: ..." or
+	// "-- +migration Description: ..." line preceding the first statement.
+	Description string
+
+	// Requires lists the IDs of migrations that must be applied first, taken
+	// from "-- requires: a, b" or "-- +migration Requires: a, b" lines
+	// preceding the first statement.
+	Requires []string
+
+	// Irreversible is set by a "-- +migration Irreversible" line, marking an
+	// up migration that deliberately has no down migration.
+	Irreversible bool
+
+	// MinServerVersion is the oldest database server version the migration
+	// can run on, such as "12" or "8.0.13", taken from a
+	// "-- +migration MinServerVersion: ..." line.
+	MinServerVersion string
+
+	// Tags are free-form labels, taken from the front-matter, for callers to
+	// select or report on migrations by.
+	Tags []string
+}
+
+// Equal reports whether p and other use the same transaction mode, contain
+// the same statements in the same order and declare the same metadata.
+func (p *ParsedMigration) Equal(other *ParsedMigration) bool {
+	return len(p.Diff(other)) == 0
+}
+
+// Diff returns a human-readable description of each difference between p and
+// other. It returns nil if they are equal.
+func (p *ParsedMigration) Diff(other *ParsedMigration) []string {
+	if p == nil || other == nil {
+		if p == other {
+			return nil
+		}
+		return []string{"only one of the migrations is nil"}
+	}
+
+	var diffs []string
+
+	if p.UseTransaction != other.UseTransaction {
+		diffs = append(diffs, fmt.Sprintf("UseTransaction differs: %t != %t", p.UseTransaction, other.UseTransaction))
+	}
+
+	if p.Description != other.Description {
+		diffs = append(diffs, fmt.Sprintf("Description differs: %q != %q", p.Description, other.Description))
+	}
+
+	if strings.Join(p.Requires, ",") != strings.Join(other.Requires, ",") {
+		diffs = append(diffs, fmt.Sprintf("Requires differs: %q != %q", p.Requires, other.Requires))
+	}
+
+	if strings.Join(p.Tags, ",") != strings.Join(other.Tags, ",") {
+		diffs = append(diffs, fmt.Sprintf("Tags differs: %q != %q", p.Tags, other.Tags))
+	}
+
+	if p.Irreversible != other.Irreversible {
+		diffs = append(diffs, fmt.Sprintf("Irreversible differs: %t != %t", p.Irreversible, other.Irreversible))
+	}
+
+	if p.MinServerVersion != other.MinServerVersion {
+		diffs = append(diffs, fmt.Sprintf("MinServerVersion differs: %q != %q", p.MinServerVersion, other.MinServerVersion))
+	}
+
+	if len(p.Statements) != len(other.Statements) {
+		diffs = append(diffs, fmt.Sprintf("statement count differs: %d != %d", len(p.Statements), len(other.Statements)))
+	}
+
+	for i := 0; i < len(p.Statements) || i < len(other.Statements); i++ {
+		switch {
+		case i >= len(other.Statements):
+			diffs = append(diffs, fmt.Sprintf("statement %d is missing from other: %q", i, p.Statements[i]))
+		case i >= len(p.Statements):
+			diffs = append(diffs, fmt.Sprintf("statement %d is only in other: %q", i, other.Statements[i]))
+		case p.Statements[i] != other.Statements[i]:
+			diffs = append(diffs, fmt.Sprintf("statement %d differs: %q != %q", i, p.Statements[i], other.Statements[i]))
+		}
+	}
+
+	return diffs
 }
 
-func splitStatementsBySemicolon(buf string) []string {
-	statements := strings.SplitAfter(buf, ";")
+// SplitStatements splits sql into statements after each semicolon. Semicolons
+// inside quoted strings, quoted identifiers, comments and dollar-quoted strings
+// do not end a statement. Each statement keeps its terminating semicolon and
+// trailing whitespace after the last statement is attached to it, so joining
+// the statements reproduces sql exactly. A COPY ... FROM stdin statement keeps
+// the data lines following it, up to and including the \. line ending them.
+func SplitStatements(sql string) []string {
+	var statements []string
 
-	for i, statement := range statements {
-		trimmed := strings.TrimSpace(statement)
-		if trimmed == "" && i > 0 {
-			statements[i-1] += statements[i]
-			statements = statements[:i+copy(statements[i:], statements[i+1:])]
+	start := 0
+	for start < len(sql) {
+		end, ok := statementEnd(sql, start)
+		if !ok {
+			break
 		}
+		if copyFromStdinRegex.MatchString(sql[start:end]) {
+			end = skipCopyData(sql, end)
+		}
+		statements = append(statements, sql[start:end])
+		start = end
+	}
+
+	rest := sql[start:]
+	if len(statements) > 0 && strings.TrimSpace(rest) == "" {
+		statements[len(statements)-1] += rest
+	} else if rest != "" || len(statements) == 0 {
+		statements = append(statements, rest)
 	}
 
 	return statements
 }
 
+// statementEnd returns the index after the semicolon ending the statement
+// starting at i, and false if the statement is not terminated.
+func statementEnd(sql string, i int) (int, bool) {
+	for i < len(sql) {
+		switch c := sql[i]; {
+		case c == ';':
+			return i + 1, true
+		case c == '\'':
+			i = skipQuoted(sql, i, '\'', isEscapeString(sql, i))
+		case c == '"' || c == '`':
+			i = skipQuoted(sql, i, c, false)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case c == '$':
+			i = skipDollarQuoted(sql, i)
+		default:
+			i++
+		}
+	}
+	return len(sql), false
+}
+
+// skipCopyData returns the index after the \. line ending the data of the
+// COPY ... FROM stdin statement ending at i. The data starts on the line
+// after the statement, and runs to the end of sql if it is not terminated.
+func skipCopyData(sql string, i int) int {
+	i = skipLineComment(sql, i)
+	for i < len(sql) {
+		end := skipLineComment(sql, i)
+		if strings.TrimRight(sql[i:end], "\r\n") == copyDataTerminator {
+			return end
+		}
+		i = end
+	}
+	return len(sql)
+}
+
+// SplitCopy splits a COPY ... FROM stdin statement, as returned by Parse or
+// SplitStatements, into the COPY command and the data lines following it,
+// without the \. line ending them. It returns false if statement does not
+// read inline data.
+func SplitCopy(statement string) (command, data string, ok bool) {
+	end, terminated := statementEnd(statement, 0)
+	if !terminated || !copyFromStdinRegex.MatchString(statement[:end]) {
+		return "", "", false
+	}
+
+	start := skipLineComment(statement, end)
+	dataEnd := start
+	for dataEnd < len(statement) {
+		lineEnd := skipLineComment(statement, dataEnd)
+		if strings.TrimRight(statement[dataEnd:lineEnd], "\r\n") == copyDataTerminator {
+			break
+		}
+		dataEnd = lineEnd
+	}
+
+	return strings.TrimSpace(statement[:end]), statement[start:dataEnd], true
+}
+
+// skipQuoted returns the index after the quoted string or identifier starting
+// at i. A doubled quote is an escaped quote. If backslashEscapes is set, a
+// backslash escapes the following character, as in PostgreSQL escape
+// strings such as E'it\'s'.
+func skipQuoted(sql string, i int, quote byte, backslashEscapes bool) int {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// isEscapeString reports whether the quote at i starts an escape string
+// constant, such as E'it\'s'.
+func isEscapeString(sql string, i int) bool {
+	if i == 0 || (sql[i-1] != 'E' && sql[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !isIdentifierChar(sql[i-2])
+}
+
+func skipLineComment(sql string, i int) int {
+	if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns the index after the block comment starting at i.
+// Block comments may be nested.
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// skipDollarQuoted returns the index after the dollar-quoted string starting
+// at i, such as $$...$$ or $body$...$body$. If the $ at i doesn't start a
+// dollar quote (for example, it is a positional parameter such as $1 or part
+// of an identifier), the index after the $ is returned.
+func skipDollarQuoted(sql string, i int) int {
+	if i > 0 && isIdentifierChar(sql[i-1]) {
+		return i + 1
+	}
+
+	end := i + 1
+	for end < len(sql) && sql[end] != '$' {
+		if !isIdentifierChar(sql[end]) || (end == i+1 && sql[end] >= '0' && sql[end] <= '9') {
+			return i + 1
+		}
+		end++
+	}
+	if end >= len(sql) {
+		return i + 1
+	}
+
+	tag := sql[i : end+1]
+	if closing := strings.Index(sql[end+1:], tag); closing >= 0 {
+		return end + 1 + closing + len(tag)
+	}
+	return len(sql)
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Option configures optional behaviour of Parse.
+type Option func(*options)
+
+type options struct {
+	keepTerminator bool
+}
+
+// WithKeepTerminator controls whether statements retain their terminating
+// semicolon. It defaults to true. When false, the trailing semicolon of each
+// statement is removed along with any whitespace around it; statements
+// returned as a single block, such as those run within a transaction, keep
+// the semicolons between the statements they contain.
+func WithKeepTerminator(keep bool) Option {
+	return func(o *options) {
+		o.keepTerminator = keep
+	}
+}
+
 // Parse reads a migration and returns a parsed migrations
-func Parse(r io.Reader) (*ParsedMigration, error) {
-	p := &ParsedMigration{
-		UseTransaction: true,
-		Statements:     []string{},
+func Parse(r io.Reader, opts ...Option) (*ParsedMigration, error) {
+	o := &options{
+		keepTerminator: true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, body, err := parseFrontMatter(contents)
+	if err != nil {
+		return p, err
+	}
+
+	p, err = parse(bytes.NewReader(body), p)
+	if err != nil || o.keepTerminator {
+		return p, err
+	}
+
+	for i, statement := range p.Statements {
+		p.Statements[i] = trimTerminator(statement)
 	}
 
+	return p, nil
+}
+
+// trimTerminator removes the trailing semicolon of statement and the
+// whitespace around it.
+func trimTerminator(statement string) string {
+	statement = strings.TrimRightFunc(statement, unicode.IsSpace)
+	return strings.TrimRightFunc(strings.TrimSuffix(statement, ";"), unicode.IsSpace)
+}
+
+// parse reads the statements and directives of a migration into p, which
+// holds the settings read from its front-matter.
+func parse(r io.Reader, p *ParsedMigration) (*ParsedMigration, error) {
 	var buf bytes.Buffer
 
 	scanner := bufio.NewScanner(r)
 	scanner.Split(scanLines)
 
 	isFirstLine := true
+	inCopy := false
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		trimmed := strings.TrimSpace(line)
 
-		if strings.HasPrefix(trimmed, sqlCmdPrefix) {
+		if inCopy {
+			buf.WriteString(line)
+			if trimmed == copyDataTerminator {
+				p.Statements = append(p.Statements, buf.String())
+				buf.Reset()
+				inCopy = false
+			}
+		} else if copyFromStdinRegex.MatchString(trimmed) {
+			// The data lines are not SQL, so the statement and its data are
+			// kept apart from the statements around them.
+			if strings.TrimSpace(buf.String()) != "" {
+				p.Statements = append(p.Statements, blockStatements(p, buf.Bytes())...)
+			}
+			buf.Reset()
+			buf.WriteString(line)
+			inCopy = true
+		} else if description, ok := parseDescription(trimmed); ok && p.Description == "" && len(p.Statements) == 0 && strings.TrimSpace(buf.String()) == "" {
+			p.Description = description
+		} else if requires, ok := parseRequires(trimmed); ok && len(p.Statements) == 0 && strings.TrimSpace(buf.String()) == "" {
+			p.Requires = append(p.Requires, requires...)
+		} else if strings.HasPrefix(trimmed, sqlCmdPrefix+optionMinServerVersion) {
+			p.MinServerVersion = strings.TrimSpace(strings.TrimPrefix(trimmed, sqlCmdPrefix+optionMinServerVersion))
+		} else if strings.HasPrefix(trimmed, sqlCmdPrefix) {
 			option := strings.Replace(trimmed, sqlCmdPrefix, "", -1)
 
 			switch option {
@@ -64,15 +410,12 @@ func Parse(r io.Reader) (*ParsedMigration, error) {
 				}
 				p.UseTransaction = false
 
+			case optionIrreversible:
+				p.Irreversible = true
+
 			case optionBeginStatement:
 				// Add lines encountered before beginning the statement
-				withoutCR := string(dropCR(buf.Bytes()))
-
-				if !p.UseTransaction {
-					p.Statements = append(p.Statements, splitStatementsBySemicolon(withoutCR)...)
-				} else {
-					p.Statements = append(p.Statements, withoutCR)
-				}
+				p.Statements = append(p.Statements, blockStatements(p, buf.Bytes())...)
 				buf.Reset()
 
 			case optionEndStatement:
@@ -88,18 +431,62 @@ func Parse(r io.Reader) (*ParsedMigration, error) {
 		isFirstLine = false
 	}
 
+	if inCopy {
+		return p, fmt.Errorf("data of %q is not terminated by a %s line", strings.TrimSpace(firstLine(buf.String())), copyDataTerminator)
+	}
+
 	// If the buffer contains lines, process them
 	if buf.Len() > 0 && strings.TrimSpace(buf.String()) != "" {
-		withoutCR := string(dropCR(buf.Bytes()))
+		p.Statements = append(p.Statements, blockStatements(p, buf.Bytes())...)
+	}
 
-		if !p.UseTransaction {
-			p.Statements = append(p.Statements, splitStatementsBySemicolon(withoutCR)...)
-		} else {
-			p.Statements = append(p.Statements, withoutCR)
+	return p, nil
+}
+
+// blockStatements returns the statements of a block of lines: the block
+// itself in a transactional migration, or the statements it contains.
+func blockStatements(p *ParsedMigration, block []byte) []string {
+	withoutCR := string(dropCR(block))
+
+	if !p.UseTransaction {
+		return SplitStatements(withoutCR)
+	}
+	return []string{withoutCR}
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// parseDescription returns the description if line is a description comment.
+func parseDescription(line string) (string, bool) {
+	for _, prefix := range []string{descriptionPrefix, sqlCmdPrefix + optionDescription} {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix)), true
 		}
 	}
+	return "", false
+}
 
-	return p, nil
+// parseRequires returns the required migration IDs if line is a requires
+// comment.
+func parseRequires(line string) ([]string, bool) {
+	for _, prefix := range []string{requiresPrefix, sqlCmdPrefix + optionRequires} {
+		if strings.HasPrefix(line, prefix) {
+			var requires []string
+			for _, id := range strings.Split(strings.TrimPrefix(line, prefix), ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					requires = append(requires, id)
+				}
+			}
+			return requires, true
+		}
+	}
+	return nil, false
 }
 
 func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -152,3 +152,395 @@ func TestNoTransactionMustBeInFirstLine(t *testing.T) {
 		t.Error("Expected parser to return error if -- +migration noTransaction was not the first line, but got no error")
 	}
 }
+
+func TestParsedMigrationEqual(t *testing.T) {
+	base := &ParsedMigration{
+		UseTransaction: true,
+		Statements:     []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (id integer);"},
+	}
+
+	testCases := []struct {
+		name  string
+		other *ParsedMigration
+		equal bool
+		diffs int
+	}{
+		{
+			name: "equal",
+			other: &ParsedMigration{
+				UseTransaction: true,
+				Statements:     []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (id integer);"},
+			},
+			equal: true,
+		},
+		{
+			name: "statement count differs",
+			other: &ParsedMigration{
+				UseTransaction: true,
+				Statements:     []string{"CREATE TABLE a (id integer);"},
+			},
+			diffs: 2,
+		},
+		{
+			name: "transaction flag differs",
+			other: &ParsedMigration{
+				UseTransaction: false,
+				Statements:     []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (id integer);"},
+			},
+			diffs: 1,
+		},
+		{
+			name: "statement differs",
+			other: &ParsedMigration{
+				UseTransaction: true,
+				Statements:     []string{"CREATE TABLE a (id integer);", "CREATE TABLE c (id integer);"},
+			},
+			diffs: 1,
+		},
+		{
+			name: "metadata differs",
+			other: &ParsedMigration{
+				UseTransaction:   true,
+				Statements:       []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (id integer);"},
+				Description:      "Create tables",
+				Requires:         []string{"1_init"},
+				Irreversible:     true,
+				MinServerVersion: "12",
+			},
+			diffs: 4,
+		},
+		{
+			name: "requires differs",
+			other: &ParsedMigration{
+				UseTransaction: true,
+				Statements:     []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (id integer);"},
+				Requires:       []string{"1_init", "2_users"},
+			},
+			diffs: 1,
+		},
+		{
+			name:  "nil",
+			other: nil,
+			diffs: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if equal := base.Equal(testCase.other); equal != testCase.equal {
+			t.Errorf("Expected Equal to be %t for test case %q, got %t", testCase.equal, testCase.name, equal)
+		}
+		if diffs := base.Diff(testCase.other); len(diffs) != testCase.diffs {
+			t.Errorf("Expected %d differences for test case %q, got %d: %v", testCase.diffs, testCase.name, len(diffs), diffs)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	testCases := []struct {
+		sql        string
+		statements []string
+	}{
+		{
+			sql:        "CREATE TABLE a (id integer);\nCREATE TABLE b (id integer);\n",
+			statements: []string{"CREATE TABLE a (id integer);", "\nCREATE TABLE b (id integer);\n"},
+		},
+		{
+			sql:        "INSERT INTO a VALUES ('it''s; fine'); SELECT 1;",
+			statements: []string{"INSERT INTO a VALUES ('it''s; fine');", " SELECT 1;"},
+		},
+		{
+			sql:        "INSERT INTO a VALUES (E'it\\'s; fine'); SELECT 1;",
+			statements: []string{"INSERT INTO a VALUES (E'it\\'s; fine');", " SELECT 1;"},
+		},
+		{
+			sql:        `CREATE TABLE "odd;name" (id integer); SELECT 1;`,
+			statements: []string{`CREATE TABLE "odd;name" (id integer);`, " SELECT 1;"},
+		},
+		{
+			sql:        "CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END; $$ LANGUAGE plpgsql; SELECT 1;",
+			statements: []string{"CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END; $$ LANGUAGE plpgsql;", " SELECT 1;"},
+		},
+		{
+			sql:        "CREATE FUNCTION f() RETURNS text AS $body$ SELECT '$$;'; $body$ LANGUAGE sql; SELECT 1;",
+			statements: []string{"CREATE FUNCTION f() RETURNS text AS $body$ SELECT '$$;'; $body$ LANGUAGE sql;", " SELECT 1;"},
+		},
+		{
+			sql:        "SELECT $1; SELECT a$b;",
+			statements: []string{"SELECT $1;", " SELECT a$b;"},
+		},
+		{
+			sql:        "/* outer /* nested; */ still; */ SELECT 1; SELECT 2;",
+			statements: []string{"/* outer /* nested; */ still; */ SELECT 1;", " SELECT 2;"},
+		},
+		{
+			sql:        "-- comment; here\nSELECT 1; SELECT 2",
+			statements: []string{"-- comment; here\nSELECT 1;", " SELECT 2"},
+		},
+		{
+			sql:        "COPY a (id, name) FROM stdin;\n1\tx;y\n2\t'z\n\\.\nSELECT 1;",
+			statements: []string{"COPY a (id, name) FROM stdin;\n1\tx;y\n2\t'z\n\\.\n", "SELECT 1;"},
+		},
+	}
+
+	for i, testCase := range testCases {
+		statements := SplitStatements(testCase.sql)
+		if !reflect.DeepEqual(statements, testCase.statements) {
+			t.Errorf("Split statements for test case %d did not match, expected %q, got %q", i, testCase.statements, statements)
+		}
+	}
+}
+
+func TestParseDescription(t *testing.T) {
+	testMigrations := []struct {
+		statements  string
+		description string
+		result      []string
+		transaction bool
+	}{
+		{
+			statements:  "-- description: Create the users table\nCREATE TABLE users (id integer);\n",
+			description: "Create the users table",
+			result:      []string{"CREATE TABLE users (id integer);\n"},
+			transaction: true,
+		},
+		{
+			statements:  "-- +migration Description: Create the users table \n-- +migration NoTransaction\nCREATE TABLE users (id integer);\n",
+			description: "Create the users table",
+			result:      []string{"CREATE TABLE users (id integer);\n"},
+			transaction: false,
+		},
+		{
+			statements:  "CREATE TABLE users (id integer);\n-- description: Not leading\n",
+			description: "",
+			result:      []string{"CREATE TABLE users (id integer);\n-- description: Not leading\n"},
+			transaction: true,
+		},
+	}
+
+	for i, testMigration := range testMigrations {
+		migration, err := Parse(strings.NewReader(testMigration.statements))
+		if err != nil {
+			t.Fatalf("Error parsing migration %d: %s", i, err)
+		}
+
+		if migration.Description != testMigration.description {
+			t.Errorf("Expected description of migration %d to be %q, got %q", i, testMigration.description, migration.Description)
+		}
+
+		if migration.UseTransaction != testMigration.transaction {
+			t.Errorf("Expected transaction of migration %d to be %t, got %t", i, testMigration.transaction, migration.UseTransaction)
+		}
+
+		if !reflect.DeepEqual(migration.Statements, testMigration.result) {
+			t.Errorf("Expected statements of migration %d to be %q, got %q", i, testMigration.result, migration.Statements)
+		}
+	}
+}
+
+func TestParseRequires(t *testing.T) {
+	testMigration := "-- description: Add user emails\n-- requires: 1_shared_users, 2_shared_roles\n-- +migration Requires: 3_shared_audit\nALTER TABLE users ADD COLUMN email text;\n-- requires: 4_not_leading\n"
+
+	migration, err := Parse(strings.NewReader(testMigration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"1_shared_users", "2_shared_roles", "3_shared_audit"}
+	if !reflect.DeepEqual(migration.Requires, expected) {
+		t.Errorf("Expected requires to be %q, got %q", expected, migration.Requires)
+	}
+
+	if migration.Description != "Add user emails" {
+		t.Errorf("Expected description to be %q, got %q", "Add user emails", migration.Description)
+	}
+}
+
+func TestParseIrreversible(t *testing.T) {
+	migration, err := Parse(strings.NewReader("-- +migration Irreversible\nDROP TABLE legacy_users;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !migration.Irreversible {
+		t.Error("Expected the migration to be marked irreversible")
+	}
+	if !reflect.DeepEqual(migration.Statements, []string{"DROP TABLE legacy_users;\n"}) {
+		t.Errorf("Expected the directive not to be part of the statements, got %q", migration.Statements)
+	}
+
+	migration, err = Parse(strings.NewReader("DROP TABLE legacy_users;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.Irreversible {
+		t.Error("Expected the migration not to be marked irreversible")
+	}
+}
+
+func TestParseMinServerVersion(t *testing.T) {
+	migration, err := Parse(strings.NewReader("-- +migration MinServerVersion: 12\nALTER TABLE users ADD COLUMN name_lower text GENERATED ALWAYS AS (lower(name)) STORED;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migration.MinServerVersion != "12" {
+		t.Errorf("Expected the minimum server version to be %q, got %q", "12", migration.MinServerVersion)
+	}
+	if len(migration.Statements) != 1 || strings.Contains(migration.Statements[0], "MinServerVersion") {
+		t.Errorf("Expected the directive not to be part of the statements, got %q", migration.Statements)
+	}
+}
+
+func TestParseKeepTerminator(t *testing.T) {
+	testMigration := "-- +migration NoTransaction\nCREATE TABLE a (id integer);\nCREATE TABLE b (id integer) ;\n\nSELECT 'x;y';\n"
+
+	migration, err := Parse(strings.NewReader(testMigration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"CREATE TABLE a (id integer);", "\nCREATE TABLE b (id integer) ;", "\n\nSELECT 'x;y';\n"}
+	if !reflect.DeepEqual(migration.Statements, expected) {
+		t.Errorf("Expected statements to keep their terminators by default: %q, got %q", expected, migration.Statements)
+	}
+
+	migration, err = Parse(strings.NewReader(testMigration), WithKeepTerminator(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{"CREATE TABLE a (id integer)", "\nCREATE TABLE b (id integer)", "\n\nSELECT 'x;y'"}
+	if !reflect.DeepEqual(migration.Statements, expected) {
+		t.Errorf("Expected statements without terminators: %q, got %q", expected, migration.Statements)
+	}
+}
+
+func TestParseCopy(t *testing.T) {
+	testMigration := "CREATE TABLE users (id integer, name text);\n\nCOPY users (id, name) FROM stdin;\n1\talice\n2\tbob; the builder\n3\t\\N\n\\.\n\nCREATE INDEX users_name ON users (name);\n"
+
+	migration, err := Parse(strings.NewReader(testMigration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"CREATE TABLE users (id integer, name text);\n\n",
+		"COPY users (id, name) FROM stdin;\n1\talice\n2\tbob; the builder\n3\t\\N\n\\.\n",
+		"\nCREATE INDEX users_name ON users (name);\n",
+	}
+	if !reflect.DeepEqual(migration.Statements, expected) {
+		t.Fatalf("Expected the COPY statement and its data to be a statement of their own: %q, got %q", expected, migration.Statements)
+	}
+
+	command, data, ok := SplitCopy(migration.Statements[1])
+	if !ok {
+		t.Fatal("Expected the statement to be recognized as a COPY with inline data")
+	}
+	if command != "COPY users (id, name) FROM stdin;" {
+		t.Errorf("Expected the COPY command, got %q", command)
+	}
+	if data != "1\talice\n2\tbob; the builder\n3\t\\N\n" {
+		t.Errorf("Expected the data lines without the terminator, got %q", data)
+	}
+
+	if _, _, ok := SplitCopy("COPY users TO stdout;"); ok {
+		t.Error("Expected a COPY without inline data not to be split")
+	}
+
+	_, err = Parse(strings.NewReader("COPY users (id, name) FROM stdin;\n1\talice\n"))
+	if err == nil || !strings.Contains(err.Error(), "not terminated") {
+		t.Errorf("Expected an error for unterminated COPY data, got %v", err)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	testMigrations := []struct {
+		name        string
+		statements  string
+		description string
+		tags        []string
+		requires    []string
+		result      []string
+		transaction bool
+	}{
+		{
+			name:        "without front-matter",
+			statements:  "CREATE TABLE users (id integer);\n",
+			result:      []string{"CREATE TABLE users (id integer);\n"},
+			transaction: true,
+		},
+		{
+			name:        "every field",
+			statements:  "/* ---\ndescription: \"Create the users table\"\nuse_transaction: false\ntags: [schema, users]\nrequires: 1_init, 2_roles\n--- */\nCREATE TABLE users (id integer);\nCREATE INDEX users_id ON users (id);\n",
+			description: "Create the users table",
+			tags:        []string{"schema", "users"},
+			requires:    []string{"1_init", "2_roles"},
+			result:      []string{"CREATE TABLE users (id integer);", "\nCREATE INDEX users_id ON users (id);\n"},
+			transaction: false,
+		},
+		{
+			name:        "block lists",
+			statements:  "\n/*---\n# labels\ntags:\n  - schema\n  - 'users'\n\nrequires:\n  - 1_init\n---*/\nCREATE TABLE users (id integer);\n",
+			tags:        []string{"schema", "users"},
+			requires:    []string{"1_init"},
+			result:      []string{"CREATE TABLE users (id integer);\n"},
+			transaction: true,
+		},
+		{
+			name:        "combined with comment directives",
+			statements:  "/* ---\ndescription: Create the users table\nrequires: [1_init]\n--- */\n-- requires: 2_roles\nCREATE TABLE users (id integer);\n",
+			description: "Create the users table",
+			requires:    []string{"1_init", "2_roles"},
+			result:      []string{"CREATE TABLE users (id integer);\n"},
+			transaction: true,
+		},
+		{
+			name:        "block comment that is not front-matter",
+			statements:  "/* users */\nCREATE TABLE users (id integer);\n",
+			result:      []string{"/* users */\nCREATE TABLE users (id integer);\n"},
+			transaction: true,
+		},
+	}
+
+	for _, test := range testMigrations {
+		migration, err := Parse(strings.NewReader(test.statements))
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", test.name, err)
+			continue
+		}
+
+		if migration.Description != test.description {
+			t.Errorf("Expected description %q for %s, got %q", test.description, test.name, migration.Description)
+		}
+		if !reflect.DeepEqual(migration.Tags, test.tags) {
+			t.Errorf("Expected tags %q for %s, got %q", test.tags, test.name, migration.Tags)
+		}
+		if !reflect.DeepEqual(migration.Requires, test.requires) {
+			t.Errorf("Expected requires %q for %s, got %q", test.requires, test.name, migration.Requires)
+		}
+		if !reflect.DeepEqual(migration.Statements, test.result) {
+			t.Errorf("Expected statements %q for %s, got %q", test.result, test.name, migration.Statements)
+		}
+		if migration.UseTransaction != test.transaction {
+			t.Errorf("Expected UseTransaction to be %t for %s, got %t", test.transaction, test.name, migration.UseTransaction)
+		}
+	}
+}
+
+func TestParseMalformedFrontMatter(t *testing.T) {
+	testMigrations := map[string]string{
+		"not terminated by":       "/* ---\ndescription: Create the users table\nCREATE TABLE users (id integer);\n",
+		"expected \"key: value\"": "/* ---\ndescription Create the users table\n--- */\nCREATE TABLE users (id integer);\n",
+		"must be true or false":   "/* ---\nuse_transaction: sometimes\n--- */\nCREATE TABLE users (id integer);\n",
+		"unknown key \"tag\"":     "/* ---\ntag: schema\n--- */\nCREATE TABLE users (id integer);\n",
+		"closing bracket":         "/* ---\ntags: [schema, users\n--- */\nCREATE TABLE users (id integer);\n",
+		"does not follow a list":  "/* ---\ndescription: Create the users table\n  - schema\n--- */\nCREATE TABLE users (id integer);\n",
+	}
+
+	for expected, statements := range testMigrations {
+		_, err := Parse(strings.NewReader(statements))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
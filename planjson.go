@@ -0,0 +1,56 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// JSONPlanVersion is the version of the document produced by PlanJSON. It is
+// incremented whenever a field is changed or removed; fields may be added
+// without changing it.
+const JSONPlanVersion = 1
+
+// JSONPlan is the document produced by PlanJSON.
+type JSONPlan struct {
+	Version    int                    `json:"version"`
+	Direction  string                 `json:"direction"`
+	Migrations []JSONPlannedMigration `json:"migrations"`
+}
+
+// JSONPlannedMigration describes a planned migration in a JSONPlan.
+type JSONPlannedMigration struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+
+	// Statements is the number of SQL statements the migration runs.
+	Statements    int  `json:"statements"`
+	Transactional bool `json:"transactional"`
+}
+
+// PlanJSON returns the migrations that Migrate would apply in direction as a
+// JSON-encoded JSONPlan, for consumption by tools such as CI pipelines. It
+// plans like Plan with no maximum.
+func PlanJSON(ctx context.Context, driver Driver, migrations Source, direction Direction, opts ...Option) ([]byte, error) {
+	planned, err := Plan(ctx, driver, migrations, direction, 0, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := JSONPlan{
+		Version:    JSONPlanVersion,
+		Direction:  direction.String(),
+		Migrations: make([]JSONPlannedMigration, 0, len(planned)),
+	}
+
+	for _, plannedMigration := range planned {
+		statements := statementsFor(plannedMigration)
+		doc.Migrations = append(doc.Migrations, JSONPlannedMigration{
+			ID:            plannedMigration.ID,
+			Direction:     plannedMigration.Direction.String(),
+			Statements:    countStatements(statements),
+			Transactional: statements == nil || statements.UseTransaction,
+		})
+	}
+
+	return json.Marshal(doc)
+}
@@ -0,0 +1,45 @@
+package migration
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPlanJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+			"002_update.up.sql": "ALTER TABLE users ADD COLUMN name text;\nALTER TABLE users ADD COLUMN email text;\n",
+			"003_index.up.sql":  "-- +migration NoTransaction\nCREATE INDEX CONCURRENTLY users_name ON users (name);\n",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	data, err := PlanJSON(ctx, driver, memoryMigration, Up)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning: %s", err)
+	}
+
+	expected := `{"version":1,"direction":"up","migrations":[` +
+		`{"id":"002_update","direction":"up","statements":2,"transactional":true},` +
+		`{"id":"003_index","direction":"up","statements":1,"transactional":false}]}`
+	if string(data) != expected {
+		t.Errorf("Expected plan\n%s\ngot\n%s", expected, data)
+	}
+
+	driver.applied = []string{"001_init", "002_update", "003_index"}
+
+	data, err = PlanJSON(ctx, driver, memoryMigration, Up)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning: %s", err)
+	}
+	if string(data) != `{"version":1,"direction":"up","migrations":[]}` {
+		t.Errorf("Expected an empty list of migrations, got %s", data)
+	}
+}
@@ -0,0 +1,70 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// DriverFactory creates a driver connected to dsn.
+type DriverFactory func(ctx context.Context, dsn string) (Driver, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]DriverFactory{}
+)
+
+// Register makes a driver available to Open for DSNs with the given URL
+// scheme, such as "postgres". Driver packages usually call it from init. It
+// panics if factory is nil or the scheme is already registered.
+func Register(scheme string, factory DriverFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("migration: Register factory is nil")
+	}
+	if _, ok := registry[scheme]; ok {
+		panic("migration: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = factory
+}
+
+// Drivers returns the registered schemes in sorted order.
+func Drivers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Open creates a driver for dsn using the factory registered for its URL
+// scheme. The driver package must have been imported, for example with
+// import _ "github.com/muxinc/migration/driver/postgres".
+func Open(ctx context.Context, dsn string) (Driver, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %w", err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("DSN has no scheme to select a driver")
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no driver registered for scheme %q (forgotten import?)", u.Scheme)
+	}
+
+	return factory(ctx, dsn)
+}
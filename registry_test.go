@@ -0,0 +1,49 @@
+package migration
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	driver := getMockDriver()
+
+	var opened string
+	Register("fake", func(ctx context.Context, dsn string) (Driver, error) {
+		opened = dsn
+		return driver, nil
+	})
+
+	d, err := Open(ctx, "fake://user@localhost/db")
+	if err != nil {
+		t.Fatalf("Unexpected error while opening a registered scheme: %s", err)
+	}
+	if d != driver || opened != "fake://user@localhost/db" {
+		t.Errorf("Expected the registered factory to be called with the DSN, got %q", opened)
+	}
+
+	found := false
+	for _, scheme := range Drivers() {
+		if scheme == "fake" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the fake scheme to be listed, got %v", Drivers())
+	}
+
+	if _, err := Open(ctx, "unknown://localhost"); err == nil {
+		t.Error("Expected an error when opening an unregistered scheme")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a scheme twice to panic")
+		}
+	}()
+	Register("fake", func(ctx context.Context, dsn string) (Driver, error) {
+		return nil, nil
+	})
+}
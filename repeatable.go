@@ -0,0 +1,112 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// repeatableRegex matches the files of repeatable migrations, such as
+// "R__user_view.sql". The ID of the migration is the file name without its
+// extension.
+var repeatableRegex = regexp.MustCompile(`^(R__.+)\.[^.]+$`)
+
+// getRepeatables loads the repeatable migrations of the source, sorted by ID.
+// Repeatable migrations have no down migration: they are re-applied whenever
+// their content changes, typically to recreate views or functions.
+func getRepeatables(migrations Source, o *options) ([]*Migration, error) {
+	files, err := migrations.ListMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var repeatables []*Migration
+	for _, file := range files {
+		matches := repeatableRegex.FindStringSubmatch(file)
+		if matches == nil {
+			continue
+		}
+
+		parsed, _, err := parseMigrationFile(migrations, file, matches[1], o)
+		if err != nil {
+			return nil, err
+		}
+
+		repeatables = append(repeatables, &Migration{
+			ID:          matches[1],
+			Up:          parsed,
+			Description: parsed.Description,
+			Repeatable:  true,
+		})
+	}
+
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].ID < repeatables[j].ID
+	})
+
+	return repeatables, nil
+}
+
+// planRepeatables returns the repeatable migrations that have never been
+// applied or whose checksum differs from the one last applied.
+func planRepeatables(ctx context.Context, driver Driver, repeatables []*Migration) ([]*PlannedMigration, error) {
+	if len(repeatables) == 0 {
+		return nil, nil
+	}
+
+	migrator, ok := driver.(RepeatableMigrator)
+	if !ok {
+		return nil, fmt.Errorf("driver %T does not support repeatable migrations", driver)
+	}
+
+	checksums, err := migrator.RepeatableChecksums(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var planned []*PlannedMigration
+	for _, repeatable := range repeatables {
+		if checksums[repeatable.ID] != repeatable.Checksum() {
+			planned = append(planned, &PlannedMigration{Migration: repeatable, Direction: Up})
+		}
+	}
+
+	return planned, nil
+}
+
+// migrateRepeatables applies the repeatable migrations whose content changed,
+// returning the IDs of those applied. It stops at the first failure, or the
+// first migration declined by the callback set with WithConfirm.
+func migrateRepeatables(ctx context.Context, driver Driver, repeatables []*Migration, l Logger, o *options) ([]string, error) {
+	planned, err := planRepeatables(ctx, driver, repeatables)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, plannedMigration := range planned {
+		confirmed, err := confirm(plannedMigration, l, o)
+		if err != nil {
+			return applied, err
+		}
+		if !confirmed {
+			break
+		}
+
+		logPrintf(l, "Applying repeatable migration named '%s'...", plannedMigration.ID)
+
+		if err := driver.(RepeatableMigrator).MigrateRepeatable(ctx, plannedMigration); err != nil {
+			return applied, &MigrationError{
+				ID:        plannedMigration.ID,
+				Direction: plannedMigration.Direction,
+				Err:       err,
+			}
+		}
+
+		logPrintf(l, "Applied repeatable migration named '%s'", plannedMigration.ID)
+		applied = append(applied, plannedMigration.ID)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRepeatableMigrations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"01_init.up.sql":      "CREATE TABLE users (id integer);\n",
+			"R__user_view.sql":    "CREATE OR REPLACE VIEW user_ids AS SELECT id FROM users;\n",
+			"R__user_counter.sql": "CREATE OR REPLACE FUNCTION user_count() RETURNS bigint AS 'SELECT count(*) FROM users' LANGUAGE sql;\n",
+		},
+	}
+
+	driver := getMockDriver()
+
+	result := Run(ctx, driver, memoryMigration, Up, 0, nil)
+	if result.Err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", result.Err)
+	}
+
+	expected := []string{"01_init", "R__user_counter", "R__user_view"}
+	if len(result.Applied) != len(expected) {
+		t.Fatalf("Expected %v to be applied, got %v", expected, result.Applied)
+	}
+	for i, id := range expected {
+		if result.Applied[i] != id {
+			t.Errorf("Expected %v to be applied in order, got %v", expected, result.Applied)
+			break
+		}
+	}
+
+	if len(driver.applied) != 1 || driver.applied[0] != "01_init" {
+		t.Errorf("Expected repeatable migrations not to be recorded as versions, got %v", driver.applied)
+	}
+
+	// Unchanged repeatable migrations are skipped.
+	driver.executed = nil
+
+	count, err := Migrate(ctx, driver, memoryMigration, Up, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if count != 0 || len(driver.executed) != 0 {
+		t.Errorf("Expected unchanged repeatable migrations to be skipped, got %d applied and %v executed", count, driver.executed)
+	}
+
+	// Changed repeatable migrations are run again, after versioned ones.
+	previousChecksum := driver.repeatables["R__user_view"]
+	memoryMigration.Files["R__user_view.sql"] = "CREATE OR REPLACE VIEW user_ids AS SELECT id FROM users WHERE id > 0;\n"
+	memoryMigration.Files["02_roles.up.sql"] = "CREATE TABLE roles (id integer);\n"
+
+	planned, err := Plan(ctx, driver, memoryMigration, Up, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error while planning: %s", err)
+	}
+	if len(planned) != 2 || planned[0].ID != "02_roles" || planned[1].ID != "R__user_view" {
+		t.Errorf("Expected 02_roles then R__user_view to be planned, got %v", planned)
+	}
+
+	count, err = Migrate(ctx, driver, memoryMigration, Up, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 migrations to be applied, got %d", count)
+	}
+	if driver.repeatables["R__user_view"] == previousChecksum {
+		t.Error("Expected the checksum of the changed repeatable migration to be recorded")
+	}
+
+	// Repeatable migrations are not run when migrating to a maximum.
+	memoryMigration.Files["R__user_view.sql"] = "CREATE OR REPLACE VIEW user_ids AS SELECT 1 AS id;\n"
+
+	count, err = Migrate(ctx, driver, memoryMigration, Up, 1, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no migration to be applied, got %d", count)
+	}
+}
@@ -0,0 +1,78 @@
+package migration
+
+import (
+	"context"
+	"errors"
+)
+
+// RunResult summarizes a call to Run.
+type RunResult struct {
+	Direction Direction
+
+	// Applied lists the IDs of the migrations applied, or rolled back when
+	// migrating down, in the order they were run.
+	Applied []string
+
+	// Skipped lists the IDs of the planned migrations that were not applied
+	// because their guard did not pass.
+	Skipped []string
+
+	// StartVersion and EndVersion are the IDs of the latest applied migration
+	// before and after the run, or empty if no migration was applied.
+	StartVersion string
+	EndVersion   string
+
+	// Err is the error that ended the run, if any.
+	Err error
+}
+
+// Run migrates like Migrate, but returns a RunResult summarizing the run
+// rather than only the number of migrations applied, for example to feed
+// logging, metrics or deploy annotations. The result is never nil; if the run
+// failed, its Err is set.
+func Run(ctx context.Context, driver Driver, migrations Source, direction Direction, max int, l Logger, opts ...Option) *RunResult {
+	result := &RunResult{Direction: direction}
+	_, result.Err = run(ctx, driver, migrations, direction, max, l, newOptions(opts), result)
+	return result
+}
+
+// latestVersion returns the ID of the latest applied migration in canonical
+// order, or an empty string if none is applied.
+func latestVersion(ctx context.Context, driver Driver) (string, error) {
+	appliedMigrations, err := driver.Versions(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	applied := toMigrations(appliedMigrations)
+	if len(applied) == 0 {
+		return "", nil
+	}
+	return applied[len(applied)-1].ID, nil
+}
+
+// appliedIDs returns the IDs of the planned migrations that were run, given
+// the number of migrations migrate reported as run and the error it returned.
+// Migrations are run in order and stop at the first failure, unless failures
+// are collected into MigrationErrors, in which case the failed migrations
+// are skipped over.
+func appliedIDs(planned []*PlannedMigration, count int, err error) []string {
+	var failed MigrationErrors
+	errors.As(err, &failed)
+
+	failedIDs := map[string]bool{}
+	for _, migrationErr := range failed {
+		failedIDs[migrationErr.ID] = true
+	}
+
+	var ids []string
+	for _, plannedMigration := range planned {
+		if len(ids) == count {
+			break
+		}
+		if !failedIDs[plannedMigration.ID] {
+			ids = append(ids, plannedMigration.ID)
+		}
+	}
+	return ids
+}
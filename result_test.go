@@ -0,0 +1,70 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql":        "CREATE TABLE users (id integer);\n",
+			"002_update.up.sql":      "ALTER TABLE users ADD COLUMN name text;\n",
+			"003_backfill.up.sql":    "UPDATE users SET name = 'unknown';\n",
+			"003_backfill.guard.sql": "SELECT false;\n",
+			"004_index.up.sql":       "error\n",
+			"005_seed.up.sql":        "INSERT INTO users VALUES (1);\n",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.applied = []string{"001_init"}
+
+	result := Run(ctx, driver, memoryMigration, Up, 0, testLogger, WithContinueOnMigrationError())
+
+	var failed MigrationErrors
+	if !errors.As(result.Err, &failed) || len(failed) != 1 || failed[0].ID != "004_index" {
+		t.Errorf("Expected 004_index to fail, got %v", result.Err)
+	}
+
+	expected := &RunResult{
+		Direction:    Up,
+		Applied:      []string{"002_update", "005_seed"},
+		Skipped:      []string{"003_backfill"},
+		StartVersion: "001_init",
+		EndVersion:   "005_seed",
+		Err:          result.Err,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected result %+v, got %+v", expected, result)
+	}
+
+	// Leave no unapplied migrations behind, as they would be caught up.
+	memoryMigration.Files = map[string]string{
+		"001_init.up.sql":   "CREATE TABLE users (id integer);\n",
+		"002_update.up.sql": "ALTER TABLE users ADD COLUMN name text;\n",
+		"005_seed.up.sql":   "INSERT INTO users VALUES (1);\n",
+		"005_seed.down.sql": "DELETE FROM users;\n",
+	}
+
+	result = Run(ctx, driver, memoryMigration, Down, 1, testLogger)
+	if result.Err != nil {
+		t.Fatalf("Unexpected error while rolling back: %s", result.Err)
+	}
+
+	expected = &RunResult{
+		Direction:    Down,
+		Applied:      []string{"005_seed"},
+		StartVersion: "005_seed",
+		EndVersion:   "002_update",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected result %+v, got %+v", expected, result)
+	}
+}
@@ -0,0 +1,87 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// checkServerVersion refuses to apply migrations that declare a minimum server
+// version newer than the server the driver is connected to. The server
+// version is only asked for if a planned migration declares one.
+func checkServerVersion(ctx context.Context, driver Driver, migrationsToApply []*PlannedMigration) error {
+	var serverVersion string
+
+	for _, plannedMigration := range migrationsToApply {
+		statements := statementsFor(plannedMigration)
+		if statements == nil || statements.MinServerVersion == "" {
+			continue
+		}
+
+		if serverVersion == "" {
+			versioner, ok := driver.(ServerVersioner)
+			if !ok {
+				return fmt.Errorf("migration %s requires server version %s, but driver %T cannot report its server version", plannedMigration.ID, statements.MinServerVersion, driver)
+			}
+
+			var err error
+			if serverVersion, err = versioner.ServerVersion(ctx); err != nil {
+				return fmt.Errorf("error getting server version: %w", err)
+			}
+		}
+
+		if compareVersions(serverVersion, statements.MinServerVersion) < 0 {
+			return &ServerVersionError{
+				ID:       plannedMigration.ID,
+				Required: statements.MinServerVersion,
+				Actual:   serverVersion,
+			}
+		}
+	}
+
+	return nil
+}
+
+// compareVersions compares the leading dotted numbers of versions a and b,
+// such as "14.5" in "14.5 (Debian 14.5-1)", returning -1, 0 or 1. Missing
+// components count as 0, so "12" and "12.0" are equal.
+func compareVersions(a, b string) int {
+	as, bs := versionNumbers(a), versionNumbers(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionNumbers(version string) []int {
+	version = strings.TrimSpace(version)
+	end := strings.IndexFunc(version, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end >= 0 {
+		version = version[:end]
+	}
+
+	var numbers []int
+	for _, component := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(component)
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
@@ -0,0 +1,63 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMinServerVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	memoryMigration := &MemoryMigrationSource{
+		Files: map[string]string{
+			"001_init.up.sql": "CREATE TABLE users (id integer, name text);\n",
+			"002_generated.up.sql": "-- +migration MinServerVersion: 12\n" +
+				"ALTER TABLE users ADD COLUMN name_lower text GENERATED ALWAYS AS (lower(name)) STORED;\n",
+		},
+	}
+
+	driver := getMockDriver()
+	driver.serverVersion = "11.19 (Debian 11.19-1.pgdg110+1)"
+
+	count, err := Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	var versionErr *ServerVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Expected a ServerVersionError, got %v", err)
+	}
+	if versionErr.ID != "002_generated" || versionErr.Required != "12" {
+		t.Errorf("Expected 002_generated to require version 12, got %s requiring %s", versionErr.ID, versionErr.Required)
+	}
+	if count != 0 || len(driver.applied) != 0 {
+		t.Errorf("Expected no migration to be applied, got %d: %v", count, driver.applied)
+	}
+
+	driver.serverVersion = "12.1"
+
+	count, err = Migrate(ctx, driver, memoryMigration, Up, 0, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 migrations to be applied, got %d", count)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"12", "12.0", 0},
+		{"11.19", "12", -1},
+		{"8.0.32-0ubuntu0.22.04.2", "8.0.13", 1},
+		{"15.2 (Debian 15.2-1.pgdg110+1)", "15.10", -1},
+		{"12", "", 1},
+	} {
+		if got := compareVersions(test.a, test.b); got != test.expected {
+			t.Errorf("Expected comparing %q with %q to return %d, got %d", test.a, test.b, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package migration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var dropTableRegex = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?([^;]+?)\s*(?:CASCADE|RESTRICT)?\s*;?$`)
+
+// Validate performs basic static checks on the up and down migrations using
+// lightweight keyword scanning, such as whether the down migration drops the
+// tables the up migration creates. It returns advisory warnings rather than
+// errors, as the scanning cannot understand every statement.
+func (m *Migration) Validate() []string {
+	var warnings []string
+
+	if isEmpty(m.Up) {
+		warnings = append(warnings, fmt.Sprintf("migration %s has no up statements", m.ID))
+	}
+	if m.Down == nil {
+		warnings = append(warnings, fmt.Sprintf("migration %s has no down migration", m.ID))
+		return warnings
+	}
+
+	dropped := map[string]bool{}
+	for _, statement := range splitStatements(m.Down) {
+		matches := dropTableRegex.FindStringSubmatch(statement)
+		if matches == nil {
+			continue
+		}
+		for _, table := range strings.Split(matches[1], ",") {
+			dropped[normalizeIdentifier(table)] = true
+		}
+	}
+
+	for _, statement := range splitStatements(m.Up) {
+		matches := createTableRegex.FindStringSubmatch(statement)
+		if matches == nil {
+			continue
+		}
+		if !dropped[normalizeIdentifier(matches[1])] {
+			warnings = append(warnings, fmt.Sprintf("migration %s creates table %s, which its down migration does not drop", m.ID, matches[1]))
+		}
+	}
+
+	return warnings
+}
+
+// normalizeIdentifier folds unquoted identifiers to lower case, as SQL does,
+// so that differently cased references to the same table compare equal.
+func normalizeIdentifier(identifier string) string {
+	identifier = strings.TrimSpace(identifier)
+	if strings.Contains(identifier, `"`) {
+		return identifier
+	}
+	return strings.ToLower(identifier)
+}
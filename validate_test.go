@@ -0,0 +1,38 @@
+package migration
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muxinc/migration/parser"
+)
+
+func TestMigrationValidate(t *testing.T) {
+	parse := func(sql string) *parser.ParsedMigration {
+		parsed, err := parser.Parse(strings.NewReader(sql))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	balanced := &Migration{
+		ID:   "1_balanced",
+		Up:   parse("CREATE TABLE users (id integer);\nCREATE TABLE IF NOT EXISTS Accounts (id integer);\n"),
+		Down: parse("DROP TABLE IF EXISTS users, accounts CASCADE;\n"),
+	}
+	if warnings := balanced.Validate(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for a balanced migration, got %q", warnings)
+	}
+
+	unbalanced := &Migration{
+		ID:   "2_unbalanced",
+		Up:   parse("CREATE TABLE users (id integer);\nCREATE TABLE accounts (id integer);\n"),
+		Down: parse("DROP TABLE users;\n"),
+	}
+	expected := []string{"migration 2_unbalanced creates table accounts, which its down migration does not drop"}
+	if warnings := unbalanced.Validate(); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %q, got %q", expected, warnings)
+	}
+}
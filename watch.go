@@ -0,0 +1,77 @@
+package migration
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// FSWatcher is a Source reading migrations from a directory of an fs.FS on
+// every call, rather than once. It is intended for development only, so that
+// a long-running server picks up migration files as they are edited without
+// being restarted.
+type FSWatcher struct {
+	fsys fs.FS
+	dir  string
+	opts []Option
+
+	mu   sync.Mutex
+	last []*Migration
+}
+
+// WatchFS returns an FSWatcher for the migrations in dir of fsys. The options
+// are used when loading migrations with Migrations.
+func WatchFS(fsys fs.FS, dir string, opts ...Option) *FSWatcher {
+	return &FSWatcher{
+		fsys: fsys,
+		dir:  dir,
+		opts: opts,
+	}
+}
+
+// ListMigrationFiles lists the files in the directory.
+func (w *FSWatcher) ListMigrationFiles() ([]string, error) {
+	entries, err := fs.ReadDir(w.fsys, w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry.Name())
+		}
+	}
+
+	return files, nil
+}
+
+// GetMigrationFile reads the current contents of a file in the directory.
+func (w *FSWatcher) GetMigrationFile(file string) (io.Reader, error) {
+	contents, err := fs.ReadFile(w.fsys, path.Join(w.dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(contents), nil
+}
+
+// Migrations re-reads and re-parses the migrations, returning them along with
+// how they changed since the previous call. On the first call, every
+// migration is reported as added.
+func (w *FSWatcher) Migrations() ([]*Migration, SetDiff, error) {
+	m, err := getMigrations(w, newOptions(w.opts))
+	if err != nil {
+		return nil, SetDiff{}, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	diff := DiffSets(w.last, m)
+	w.last = m
+
+	return m, diff, nil
+}
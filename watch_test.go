@@ -0,0 +1,46 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWatchFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_init.up.sql":   {Data: []byte("CREATE TABLE users (id integer);\n")},
+		"migrations/1_init.down.sql": {Data: []byte("DROP TABLE users;\n")},
+		"migrations/2_roles.up.sql":  {Data: []byte("CREATE TABLE roles (id integer);\n")},
+		"other/3_ignored.up.sql":     {Data: []byte("CREATE TABLE ignored (id integer);\n")},
+	}
+
+	watcher := WatchFS(fsys, "migrations")
+
+	m, diff, err := watcher.Migrations()
+	if err != nil {
+		t.Fatalf("Unexpected error reading migrations: %s", err)
+	}
+	if len(m) != 2 || !reflect.DeepEqual(diff.Added, []string{"1_init", "2_roles"}) {
+		t.Errorf("Expected 1_init and 2_roles to be added on the first read, got %d migrations and %+v", len(m), diff)
+	}
+
+	fsys["migrations/2_roles.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE roles (id bigint);\n")}
+	fsys["migrations/3_grants.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE grants (id integer);\n")}
+
+	m, diff, err = watcher.Migrations()
+	if err != nil {
+		t.Fatalf("Unexpected error re-reading migrations: %s", err)
+	}
+
+	expected := SetDiff{Added: []string{"3_grants"}, Modified: []string{"2_roles"}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected changes to be %+v, got %+v", expected, diff)
+	}
+	if len(m) != 3 || m[1].Up.Statements[0] != "CREATE TABLE roles (id bigint);\n" {
+		t.Errorf("Expected the edited migration to be re-parsed, got %v", m[1].Up.Statements)
+	}
+
+	if _, diff, _ = watcher.Migrations(); !diff.Empty() {
+		t.Errorf("Expected no changes without edits, got %+v", diff)
+	}
+}